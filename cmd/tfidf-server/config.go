@@ -0,0 +1,472 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sudalight/tools/pkg/tfidf"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix prefixes environment variables overriding the config file
+const envPrefix = "TFIDF_"
+
+type Config struct {
+	Listen      string            `yaml:"listen"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Persistence PersistenceConfig `yaml:"persistence"`
+	Scoring     ScoringConfig     `yaml:"scoring"`
+	Expiry      ExpiryConfig      `yaml:"expiry"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	Related     RelatedConfig     `yaml:"related"`
+	ANN         ANNConfig         `yaml:"ann"`
+	Features    FeaturesConfig    `yaml:"features"`
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type PersistenceConfig struct {
+	DataFile       string   `yaml:"data_file"`
+	DescriptorFile string   `yaml:"descriptor_file"`
+	LSAFile        string   `yaml:"lsa_file"`
+	SaveInterval   duration `yaml:"save_interval"`
+	SaveOnExit     bool     `yaml:"save_on_exit"`
+	FileMode       fileMode `yaml:"file_mode"`
+	// keeps docs and words in this SQLite database instead of data and descriptor files
+	SQLiteFile string `yaml:"sqlite_file"`
+	// appends every change of the change feed to this JSON lines file
+	ChangeLogFile string `yaml:"change_log_file"`
+}
+
+type ScoringConfig struct {
+	NGramMin    int                `yaml:"ngram_min"`
+	NGramMax    int                `yaml:"ngram_max"`
+	FieldBoosts map[string]float64 `yaml:"field_boosts"`
+	PerFieldIDF bool               `yaml:"per_field_idf"`
+	// dictionary of the CJK segmenter analyzing doc text, `word [freq] [tag]` per line
+	DictionaryFile string `yaml:"dictionary_file"`
+	// synonym dictionary applied at index or query time, reloaded by /admin/synonyms/reload
+	SynonymsFile string `yaml:"synonyms_file"`
+	SynonymMode  string `yaml:"synonym_mode"`
+}
+
+type ExpiryConfig struct {
+	// docs upserted without expires_at expire after ttl, 0 keeps them forever
+	TTL           duration `yaml:"ttl"`
+	SweepInterval duration `yaml:"sweep_interval"`
+}
+
+type LimitsConfig struct {
+	// bounds every route except streaming ones, 0 disables it
+	Timeout duration `yaml:"timeout"`
+	// overrides by method and path, e.g. "POST /lsa/fit": 10m
+	RouteTimeouts map[string]duration `yaml:"route_timeouts"`
+	// in bytes, 0 disables it
+	MaxBodySize int `yaml:"max_body_size"`
+	// overrides by method and path, e.g. "POST /admin/restore": 1073741824
+	RouteMaxBodySizes map[string]int `yaml:"route_max_body_sizes"`
+}
+
+type RelatedConfig struct {
+	// related docs of changed docs are refreshed every interval, 0 computes them on demand only
+	Interval   duration `yaml:"interval"`
+	K          int      `yaml:"k"`
+	MaxDFRatio float64  `yaml:"max_df_ratio"`
+	MaxTerms   int      `yaml:"max_terms"`
+}
+
+type ANNConfig struct {
+	// hash tables and bits per table of the LSH index for approximate search
+	Tables int   `yaml:"tables"`
+	Bits   int   `yaml:"bits"`
+	Seed   int64 `yaml:"seed"`
+}
+
+type FeaturesConfig struct {
+	GinMode   string `yaml:"gin_mode"`
+	Pprof     bool   `yaml:"pprof"`
+	AccessLog bool   `yaml:"access_log"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen: ":12345",
+		Persistence: PersistenceConfig{
+			DataFile:       "tfidf.json",
+			DescriptorFile: "file-descriptor.json",
+			LSAFile:        "lsa.json",
+			SaveInterval:   duration(time.Minute),
+			SaveOnExit:     true,
+			FileMode:       0777,
+		},
+		Scoring: ScoringConfig{
+			NGramMin:    1,
+			NGramMax:    1,
+			FieldBoosts: map[string]float64{},
+			SynonymMode: tfidf.SynonymsAtQuery,
+		},
+		Expiry: ExpiryConfig{
+			SweepInterval: duration(time.Minute),
+		},
+		Limits: LimitsConfig{
+			Timeout: duration(time.Minute),
+			RouteTimeouts: map[string]duration{
+				"POST /cluster":       duration(10 * time.Minute),
+				"POST /ann/rebuild":   duration(10 * time.Minute),
+				"POST /lsa/fit":       duration(10 * time.Minute),
+				"POST /train":         duration(10 * time.Minute),
+				"POST /admin/restore": duration(10 * time.Minute),
+			},
+			MaxBodySize: 64 << 20,
+			RouteMaxBodySizes: map[string]int{
+				"POST /admin/restore": 1 << 30,
+			},
+		},
+		Related: RelatedConfig{
+			Interval:   duration(time.Minute),
+			K:          10,
+			MaxDFRatio: 0.5,
+			MaxTerms:   32,
+		},
+		ANN: ANNConfig{
+			Tables: 16,
+			Bits:   8,
+			Seed:   1,
+		},
+		Features: FeaturesConfig{
+			GinMode:   gin.ReleaseMode,
+			Pprof:     true,
+			AccessLog: true,
+		},
+	}
+}
+
+// loadConfig reads the yaml file on top of defaults, an empty filename keeps defaults
+func loadConfig(filename string) (*Config, error) {
+	c := defaultConfig()
+	if filename == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// strict decoding rejects keys already in a map, route overrides of the file replace defaults
+	timeouts, sizes := c.Limits.RouteTimeouts, c.Limits.RouteMaxBodySizes
+	c.Limits.RouteTimeouts, c.Limits.RouteMaxBodySizes = nil, nil
+	err = yaml.UnmarshalStrict(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s, %s", filename, err.Error())
+	}
+	if c.Limits.RouteTimeouts == nil {
+		c.Limits.RouteTimeouts = timeouts
+	}
+	if c.Limits.RouteMaxBodySizes == nil {
+		c.Limits.RouteMaxBodySizes = sizes
+	}
+	return c, nil
+}
+
+// applyEnv overrides the config by TFIDF_* environment variables
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	strs := map[string]*string{
+		"LISTEN":          &c.Listen,
+		"TLS_CERT_FILE":   &c.TLS.CertFile,
+		"TLS_KEY_FILE":    &c.TLS.KeyFile,
+		"DATA_FILE":       &c.Persistence.DataFile,
+		"DESCRIPTOR_FILE": &c.Persistence.DescriptorFile,
+		"LSA_FILE":        &c.Persistence.LSAFile,
+		"SQLITE_FILE":     &c.Persistence.SQLiteFile,
+		"CHANGE_LOG_FILE": &c.Persistence.ChangeLogFile,
+		"DICTIONARY_FILE": &c.Scoring.DictionaryFile,
+		"SYNONYMS_FILE":   &c.Scoring.SynonymsFile,
+		"SYNONYM_MODE":    &c.Scoring.SynonymMode,
+		"GIN_MODE":        &c.Features.GinMode,
+	}
+	for name, p := range strs {
+		if v, ok := lookup(envPrefix + name); ok {
+			*p = v
+		}
+	}
+
+	ints := map[string]*int{
+		"NGRAM_MIN":         &c.Scoring.NGramMin,
+		"NGRAM_MAX":         &c.Scoring.NGramMax,
+		"MAX_BODY_SIZE":     &c.Limits.MaxBodySize,
+		"RELATED_K":         &c.Related.K,
+		"RELATED_MAX_TERMS": &c.Related.MaxTerms,
+		"ANN_TABLES":        &c.ANN.Tables,
+		"ANN_BITS":          &c.ANN.Bits,
+	}
+	for name, p := range ints {
+		if v, ok := lookup(envPrefix + name); ok {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s %q", envPrefix, name, v)
+			}
+			*p = i
+		}
+	}
+
+	if v, ok := lookup(envPrefix + "ANN_SEED"); ok {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %sANN_SEED %q", envPrefix, v)
+		}
+		c.ANN.Seed = i
+	}
+	if v, ok := lookup(envPrefix + "RELATED_MAX_DF_RATIO"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %sRELATED_MAX_DF_RATIO %q", envPrefix, v)
+		}
+		c.Related.MaxDFRatio = f
+	}
+
+	bools := map[string]*bool{
+		"SAVE_ON_EXIT":  &c.Persistence.SaveOnExit,
+		"PER_FIELD_IDF": &c.Scoring.PerFieldIDF,
+		"PPROF":         &c.Features.Pprof,
+		"ACCESS_LOG":    &c.Features.AccessLog,
+	}
+	for name, p := range bools {
+		if v, ok := lookup(envPrefix + name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s %q", envPrefix, name, v)
+			}
+			*p = b
+		}
+	}
+
+	durations := map[string]*duration{
+		"SAVE_INTERVAL":    &c.Persistence.SaveInterval,
+		"TTL":              &c.Expiry.TTL,
+		"SWEEP_INTERVAL":   &c.Expiry.SweepInterval,
+		"TIMEOUT":          &c.Limits.Timeout,
+		"RELATED_INTERVAL": &c.Related.Interval,
+	}
+	for name, p := range durations {
+		if v, ok := lookup(envPrefix + name); ok {
+			err := p.parse(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s %q", envPrefix, name, v)
+			}
+		}
+	}
+	if v, ok := lookup(envPrefix + "FILE_MODE"); ok {
+		err := c.Persistence.FileMode.parse(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sFILE_MODE %q", envPrefix, v)
+		}
+	}
+	if v, ok := lookup(envPrefix + "ROUTE_TIMEOUTS"); ok {
+		routes, err := parseRoutes(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sROUTE_TIMEOUTS, %s", envPrefix, err.Error())
+		}
+		c.Limits.RouteTimeouts = make(map[string]duration, len(routes))
+		for route, s := range routes {
+			var d duration
+			err = d.parse(s)
+			if err != nil {
+				return fmt.Errorf("invalid %sROUTE_TIMEOUTS %q of %q", envPrefix, s, route)
+			}
+			c.Limits.RouteTimeouts[route] = d
+		}
+	}
+	if v, ok := lookup(envPrefix + "ROUTE_MAX_BODY_SIZES"); ok {
+		routes, err := parseRoutes(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sROUTE_MAX_BODY_SIZES, %s", envPrefix, err.Error())
+		}
+		c.Limits.RouteMaxBodySizes = make(map[string]int, len(routes))
+		for route, s := range routes {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("invalid %sROUTE_MAX_BODY_SIZES %q of %q", envPrefix, s, route)
+			}
+			c.Limits.RouteMaxBodySizes[route] = i
+		}
+	}
+	if v, ok := lookup(envPrefix + "FIELD_BOOSTS"); ok {
+		boosts, err := parseFieldBoosts(v)
+		if err != nil {
+			return err
+		}
+		c.Scoring.FieldBoosts = boosts
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.Listen == "" {
+		return errors.New("listen is required")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls cert_file and key_file should be set together")
+	}
+	for _, f := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("tls file %s, %s", f, err.Error())
+		}
+	}
+	if c.Persistence.DataFile == "" || c.Persistence.DescriptorFile == "" || c.Persistence.LSAFile == "" {
+		return errors.New("persistence data_file, descriptor_file and lsa_file are required")
+	}
+	if c.Persistence.SaveInterval <= 0 {
+		return errors.New("persistence save_interval should be positive")
+	}
+	if c.Expiry.TTL < 0 || c.Expiry.SweepInterval <= 0 {
+		return errors.New("expiry ttl should not be negative and sweep_interval should be positive")
+	}
+	if c.Limits.Timeout < 0 || c.Limits.MaxBodySize < 0 {
+		return errors.New("limits timeout and max_body_size should not be negative")
+	}
+	for route, timeout := range c.Limits.RouteTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("timeout of route %q should not be negative", route)
+		}
+	}
+	for route, size := range c.Limits.RouteMaxBodySizes {
+		if size < 0 {
+			return fmt.Errorf("max body size of route %q should not be negative", route)
+		}
+	}
+	if c.Related.Interval < 0 || c.Related.K < 1 || c.Related.MaxTerms < 1 {
+		return errors.New("related interval should not be negative, k and max_terms should be positive")
+	}
+	if c.Related.MaxDFRatio <= 0 || c.Related.MaxDFRatio > 1 {
+		return fmt.Errorf("related max_df_ratio %v out of range (0, 1]", c.Related.MaxDFRatio)
+	}
+	if c.ANN.Tables < 1 || c.ANN.Bits < 1 || c.ANN.Bits > 64 {
+		return fmt.Errorf("ann tables should be positive and bits 1 to 64, got %d and %d", c.ANN.Tables, c.ANN.Bits)
+	}
+	if c.Persistence.FileMode&0600 != 0600 {
+		return fmt.Errorf("persistence file_mode %s should be readable and writable by owner", c.Persistence.FileMode)
+	}
+	if c.Scoring.NGramMin < 1 || c.Scoring.NGramMax < c.Scoring.NGramMin {
+		return fmt.Errorf("invalid n-gram range (%d, %d)", c.Scoring.NGramMin, c.Scoring.NGramMax)
+	}
+	if c.Scoring.DictionaryFile != "" {
+		if _, err := os.Stat(c.Scoring.DictionaryFile); err != nil {
+			return fmt.Errorf("dictionary file %s, %s", c.Scoring.DictionaryFile, err.Error())
+		}
+	}
+	switch c.Scoring.SynonymMode {
+	case tfidf.SynonymsAtIndex, tfidf.SynonymsAtQuery:
+	default:
+		return fmt.Errorf("unsupported synonym_mode %q", c.Scoring.SynonymMode)
+	}
+	for field, boost := range c.Scoring.FieldBoosts {
+		if boost < 0 {
+			return fmt.Errorf("boost of field %q should not be negative", field)
+		}
+	}
+	switch c.Features.GinMode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		return fmt.Errorf("unsupported gin_mode %q", c.Features.GinMode)
+	}
+	return nil
+}
+
+type duration time.Duration
+
+func (d *duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	s := ""
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// fileMode is written in octal, e.g. "0644"
+type fileMode os.FileMode
+
+func (m *fileMode) parse(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return err
+	}
+	*m = fileMode(v)
+	return nil
+}
+
+func (m fileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+func (m *fileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	s := ""
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+	return m.parse(s)
+}
+
+func (m fileMode) MarshalYAML() (interface{}, error) {
+	return m.String(), nil
+}
+
+func parseFieldBoosts(s string) (map[string]float64, error) {
+	boosts := make(map[string]float64)
+	if s == "" {
+		return boosts, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid field boost %q", pair)
+		}
+		boost, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field boost %q, %s", pair, err.Error())
+		}
+		boosts[strings.TrimSpace(kv[0])] = boost
+	}
+	return boosts, nil
+}
+
+// parseRoutes parses overrides by route like `POST /lsa/fit=10m,POST /train=5m`
+func parseRoutes(s string) (map[string]string, error) {
+	routes := make(map[string]string)
+	if s == "" {
+		return routes, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid route override %q", pair)
+		}
+		routes[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return routes, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -14,42 +15,164 @@ import (
 	"github.com/Sudalight/tools/pkg/tfidf"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gopkg.in/yaml.v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 var (
+	configFilename  = flag.String("config", "", "filename of yaml config, TFIDF_* environment variables override it")
+	printConfig     = flag.Bool("print-config", false, "print the effective config and exit")
+	restoreFilename = flag.String("restore", "", "load a backup downloaded from /admin/backup before serving")
+
+	// flags below override the config file and environment variables when set
 	storeFilename = flag.String("fn", "tfidf.json", "filename of tfidf persistent data")
 	fdFilename    = flag.String("fdf", "file-descriptor.json", "filename of file descriptor")
+	lsaFilename   = flag.String("lsaf", "lsa.json", "filename of lsa model")
 	port          = flag.Int("p", 12345, "service port")
+	ngramMin      = flag.Int("ngmin", 1, "lower boundary of the n-gram range")
+	ngramMax      = flag.Int("ngmax", 1, "upper boundary of the n-gram range")
+	fieldBoosts   = flag.String("boosts", "", "comma separated field boosts, e.g. title=3,tags=2")
+	perFieldIDF   = flag.Bool("pfidf", false, "compute IDF per field")
 )
 
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Llongfile)
 
-	gin.SetMode(gin.ReleaseMode)
+	conf, err := buildConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		data, err := yaml.Marshal(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(data))
+		return
+	}
+
+	gin.SetMode(conf.Features.GinMode)
 
-	router := gin.Default()
+	router := gin.New()
+	if conf.Features.AccessLog {
+		router.Use(gin.Logger())
+	}
+	router.Use(gin.Recovery())
 	router.GET("/check", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "ready perfectly",
 		})
 	})
 
-	server, err := tfidf.NewServer(*storeFilename, *fdFilename)
+	persistence := conf.Persistence
+	opts := []tfidf.Option{
+		tfidf.WithNGramRange(conf.Scoring.NGramMin, conf.Scoring.NGramMax),
+		tfidf.WithFieldBoosts(conf.Scoring.FieldBoosts),
+		tfidf.WithPerFieldIDF(conf.Scoring.PerFieldIDF),
+		tfidf.WithFileMode(os.FileMode(persistence.FileMode)),
+		tfidf.WithTTL(time.Duration(conf.Expiry.TTL)),
+		tfidf.WithRelated(tfidf.RelatedOptions{
+			K:          conf.Related.K,
+			MaxDFRatio: conf.Related.MaxDFRatio,
+			MaxTerms:   conf.Related.MaxTerms,
+		}),
+		tfidf.WithANN(tfidf.ANNOptions{
+			Tables: conf.ANN.Tables,
+			Bits:   conf.ANN.Bits,
+			Seed:   conf.ANN.Seed,
+		}),
+	}
+	if conf.Scoring.DictionaryFile != "" {
+		segmenter, err := tfidf.LoadSegmenter(conf.Scoring.DictionaryFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, tfidf.WithAnalyzer(segmenter))
+	}
+	if conf.Scoring.SynonymsFile != "" {
+		synonyms, err := tfidf.LoadSynonyms(conf.Scoring.SynonymsFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, tfidf.WithSynonyms(synonyms, conf.Scoring.SynonymMode))
+	}
+	var store tfidf.Store
+	var server *tfidf.Server
+	if persistence.SQLiteFile != "" {
+		store, err = openSQLiteStore(persistence.SQLiteFile)
+		if err != nil {
+			panic(err)
+		}
+		server, err = tfidf.NewServerWithStore(store, opts...)
+	} else {
+		store = tfidf.NewFileStore(persistence.DataFile, persistence.DescriptorFile, os.FileMode(persistence.FileMode))
+		server, err = tfidf.NewServer(persistence.DataFile, persistence.DescriptorFile, opts...)
+	}
 	if err != nil {
 		panic(err)
 	}
-	router.POST("/upsert_docs", server.UpsertDocs)
-	router.POST("/get_doc_vector", server.GetDocVector)
-	router.GET("/statistics", server.GetStatistics)
+	err = server.LoadLSA(persistence.LSAFile)
+	if err != nil {
+		panic(err)
+	}
+	if persistence.ChangeLogFile != "" {
+		err = server.OpenChangeLog(persistence.ChangeLogFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if *restoreFilename != "" {
+		err = restore(server, *restoreFilename)
+		if err != nil {
+			panic(err)
+		}
+		log.Println("restored from", *restoreFilename)
+	}
+	routeTimeouts := make(map[string]time.Duration, len(conf.Limits.RouteTimeouts))
+	for route, timeout := range conf.Limits.RouteTimeouts {
+		routeTimeouts[route] = time.Duration(timeout)
+	}
+	routeMaxBodySizes := make(map[string]int64, len(conf.Limits.RouteMaxBodySizes))
+	for route, size := range conf.Limits.RouteMaxBodySizes {
+		routeMaxBodySizes[route] = int64(size)
+	}
+	err = server.SetLimits(tfidf.Limits{
+		Timeout:           time.Duration(conf.Limits.Timeout),
+		RouteTimeouts:     routeTimeouts,
+		MaxBodySize:       int64(conf.Limits.MaxBodySize),
+		RouteMaxBodySizes: routeMaxBodySizes,
+	})
+	if err != nil {
+		panic(err)
+	}
+	server.Register(router)
+	server.StartSweeper(time.Duration(conf.Expiry.SweepInterval))
+	if conf.Related.Interval > 0 {
+		server.StartRelated(time.Duration(conf.Related.Interval))
+	}
+
+	save := func() error {
+		err := server.FlushChangeLog()
+		if err != nil {
+			return err
+		}
+		err = server.SaveTo(store)
+		if err != nil {
+			return err
+		}
+		return server.SaveLSA(persistence.LSAFile)
+	}
 
 	sigterm := make(chan os.Signal, 1)
 	go func() {
+		ticker := time.NewTicker(time.Duration(persistence.SaveInterval))
 		for {
-			timer := time.Tick(time.Minute)
 			select {
-			case <-timer:
-				err = server.Save(*storeFilename, *fdFilename)
+			case <-ticker.C:
+				err := save()
 				if err != nil {
 					log.Println(err)
 				} else {
@@ -57,7 +180,10 @@ func main() {
 					runtime.GC()
 				}
 			case <-sigterm:
-				err = server.Save(*storeFilename, *fdFilename)
+				if !persistence.SaveOnExit {
+					os.Exit(0)
+				}
+				err := save()
 				if err != nil {
 					log.Println(err)
 				} else {
@@ -70,7 +196,73 @@ func main() {
 
 	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
 
-	pprof.Register(router)
+	if conf.Features.Pprof {
+		pprof.Register(router)
+	}
 	log.Println("ready perfectly!")
-	panic(router.Run(":" + strconv.Itoa(*port)))
+	if conf.TLS.CertFile != "" {
+		panic(router.RunTLS(conf.Listen, conf.TLS.CertFile, conf.TLS.KeyFile))
+	}
+	panic(router.Run(conf.Listen))
+}
+
+// buildConfig merges defaults, config file, environment variables and flags in order
+func buildConfig() (*Config, error) {
+	conf, err := loadConfig(*configFilename)
+	if err != nil {
+		return nil, err
+	}
+	err = conf.applyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "fn":
+			conf.Persistence.DataFile = *storeFilename
+		case "fdf":
+			conf.Persistence.DescriptorFile = *fdFilename
+		case "lsaf":
+			conf.Persistence.LSAFile = *lsaFilename
+		case "p":
+			conf.Listen = ":" + strconv.Itoa(*port)
+		case "ngmin":
+			conf.Scoring.NGramMin = *ngramMin
+		case "ngmax":
+			conf.Scoring.NGramMax = *ngramMax
+		case "boosts":
+			boosts, perr := parseFieldBoosts(*fieldBoosts)
+			if perr != nil {
+				err = perr
+				return
+			}
+			conf.Scoring.FieldBoosts = boosts
+		case "pfidf":
+			conf.Scoring.PerFieldIDF = *perFieldIDF
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conf, conf.validate()
+}
+
+func openSQLiteStore(filename string) (*tfidf.SQLStore, error) {
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Warn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tfidf.NewSQLStore(db)
+}
+
+func restore(server *tfidf.Server, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return server.Restore(f)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+var commands = map[string]func(args []string) error{
+	"upsert":   runUpsert,
+	"vector":   runVector,
+	"keywords": runKeywords,
+	"stats":    runStats,
+	"export":   runExport,
+	"inspect":  runInspect,
+	"prune":    runPrune,
+	"convert":  runConvert,
+	"fsck":     runFsck,
+}
+
+var usages = map[string]string{
+	"upsert":   "upsert [-batch n] FILE...  upsert docs from .json, .jsonl or text files, - reads stdin",
+	"vector":   "vector [-explain] FILE  print the TF-IDF vector of a doc, which is upserted",
+	"keywords": "keywords [-k n] -id ID | FILE  print the top words of a stored doc, or of a doc file, which is upserted",
+	"stats":    "stats [-analytics]  print statistics of the server",
+	"export":   "export [-o FILE]  write all stored docs as JSON lines",
+	"inspect":  "inspect -store SPEC  print statistics and analytics of a snapshot",
+	"prune":    "prune -store SPEC -o SPEC [-min-df n] [-max-df ratio]  drop rare and common words from docs",
+	"convert":  "convert -store SPEC -o SPEC  copy a snapshot between files, sqlite and backup formats",
+	"fsck":     "fsck -store SPEC [-lsa FILE] [-repair] [-o SPEC]  check docs, duplicate ids, orphan words, counts, index gaps and the lsa model",
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tfidf COMMAND [FLAGS] [ARGS]")
+	fmt.Fprintln(os.Stderr, "\nonline commands talk to tfidf-server at -server or $TFIDF_SERVER, offline ones read snapshots directly.")
+	fmt.Fprintln(os.Stderr, "SPEC is files:DATA,DESCRIPTOR, sqlite:FILE or backup:FILE\n\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+usages[name])
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	err := run(os.Args[2:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tfidf "+os.Args[1]+":", err)
+		os.Exit(1)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tfidf "+usages[name])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
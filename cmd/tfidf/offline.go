@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/Sudalight/tools/pkg/tfidf"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openStore parses a store spec, files:DATA,DESCRIPTOR, sqlite:FILE or backup:FILE
+func openStore(spec string) (tfidf.Store, error) {
+	kind, path := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		kind, path = spec[:i], spec[i+1:]
+	}
+	if path == "" {
+		return nil, fmt.Errorf("invalid store %q, expected files:DATA,DESCRIPTOR, sqlite:FILE or backup:FILE", spec)
+	}
+
+	switch kind {
+	case "files":
+		files := strings.Split(path, ",")
+		if len(files) != 2 {
+			return nil, fmt.Errorf("invalid store %q, expected files:DATA,DESCRIPTOR", spec)
+		}
+		return tfidf.NewFileStore(files[0], files[1], 0644), nil
+	case "sqlite":
+		db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+			Logger: logger.Default.LogMode(logger.Warn),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return tfidf.NewSQLStore(db)
+	case "backup":
+		return &tfidf.BackupStore{Filename: path, FileMode: 0644}, nil
+	default:
+		return nil, fmt.Errorf("unknown store type %q", kind)
+	}
+}
+
+func storeFlag(fs *flag.FlagSet, name, usage string) *string {
+	return fs.String(name, "", usage+", files:DATA,DESCRIPTOR, sqlite:FILE or backup:FILE")
+}
+
+func ngramFlags(fs *flag.FlagSet) (min, max *int) {
+	min = fs.Int("ngmin", 0, "lower boundary of the n-gram range of the server, defaults to the range of the snapshot")
+	max = fs.Int("ngmax", 0, "upper boundary of the n-gram range of the server, defaults to the range of the snapshot")
+	return min, max
+}
+
+// ngramRange falls back to the n-gram range recorded in the snapshot,
+// snapshots saved before the range was recorded need both flags
+func ngramRange(s *tfidf.Snapshot, min, max int) (int, int, error) {
+	if min == 0 && max == 0 {
+		if s.NGramMin == 0 {
+			return 0, 0, errors.New("the snapshot does not record its n-gram range, -ngmin and -ngmax are required")
+		}
+		min, max = s.NGramMin, s.NGramMax
+	}
+	if min < 1 || max < min {
+		return 0, 0, fmt.Errorf("invalid n-gram range (%d, %d)", min, max)
+	}
+	return min, max, nil
+}
+
+func loadSnapshot(spec string) (*tfidf.Snapshot, error) {
+	if spec == "" {
+		return nil, errors.New("-store is required")
+	}
+	store, err := openStore(spec)
+	if err != nil {
+		return nil, err
+	}
+	return store.Load()
+}
+
+func loadTFIDF(s *tfidf.Snapshot, opts ...tfidf.Option) (*tfidf.TFIDF, error) {
+	if s.NGramMin > 0 {
+		opts = append([]tfidf.Option{tfidf.WithNGramRange(s.NGramMin, s.NGramMax)}, opts...)
+	}
+	t := tfidf.NewTFIDF(opts...)
+	return t, t.LoadFromStore(snapshotStore{s})
+}
+
+// snapshotStore serves an already loaded snapshot
+type snapshotStore struct {
+	s *tfidf.Snapshot
+}
+
+func (s snapshotStore) Load() (*tfidf.Snapshot, error) { return s.s, nil }
+
+func (s snapshotStore) Save(*tfidf.Snapshot) error {
+	return errors.New("snapshot store is read only")
+}
+
+func runInspect(args []string) error {
+	fs := newFlagSet("inspect")
+	spec := storeFlag(fs, "store", "snapshot to inspect")
+	top := fs.Int("top", tfidf.DefaultAnalyticsTop, "top and bottom words of analytics")
+	buckets := fs.Int("buckets", tfidf.DefaultAnalyticsBuckets, "buckets of the doc length histogram")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := loadSnapshot(*spec)
+	if err != nil {
+		return err
+	}
+	t, err := loadTFIDF(s)
+	if err != nil {
+		return err
+	}
+
+	labeled := 0
+	for i := range s.Docs {
+		if len(s.Docs[i].Labels) > 0 {
+			labeled++
+		}
+	}
+	return printJSON(struct {
+		Docs        int             `json:"docs"`
+		Words       int             `json:"words"`
+		LabeledDocs int             `json:"labeled_docs"`
+		Classifier  bool            `json:"classifier"`
+		Analytics   tfidf.Analytics `json:"analytics"`
+	}{
+		Docs:        len(s.Docs),
+		Words:       len(s.Words),
+		LabeledDocs: labeled,
+		Classifier:  s.Classifier != nil,
+		Analytics:   t.Analytics(*top, *buckets),
+	})
+}
+
+func runPrune(args []string) error {
+	fs := newFlagSet("prune")
+	spec := storeFlag(fs, "store", "snapshot to prune")
+	output := storeFlag(fs, "o", "where the pruned snapshot is saved")
+	minDF := fs.Int("min-df", 2, "drop words found in fewer docs")
+	maxDF := fs.Float64("max-df", 1, "drop words found in a larger ratio of docs")
+	ngramMin, ngramMax := ngramFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return errors.New("-o is required, pruning drops words for good")
+	}
+	s, err := loadSnapshot(*spec)
+	if err != nil {
+		return err
+	}
+	min, max, err := ngramRange(s, *ngramMin, *ngramMax)
+	if err != nil {
+		return err
+	}
+
+	df := make(map[string]int)
+	for i := range s.Docs {
+		seen := make(map[string]bool)
+		for _, w := range docWords(&s.Docs[i]) {
+			if !seen[w] {
+				seen[w] = true
+				df[w]++
+			}
+		}
+	}
+	keep := func(w string) bool {
+		return df[w] >= *minDF && float64(df[w]) <= *maxDF*float64(len(s.Docs))
+	}
+	dropped := 0
+	for w := range df {
+		if !keep(w) {
+			dropped++
+		}
+	}
+
+	docs := make([]tfidf.Doc, 0, len(s.Docs))
+	emptied := 0
+	for _, doc := range s.Docs {
+		doc.Words = filterWords(doc.Words, keep)
+		if doc.Fields != nil {
+			fields := make(map[string][]string, len(doc.Fields))
+			for field, words := range doc.Fields {
+				if words = filterWords(words, keep); len(words) > 0 {
+					fields[field] = words
+				}
+			}
+			doc.Fields = fields
+		}
+		if len(docWords(&doc)) == 0 {
+			emptied++
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	t := tfidf.NewTFIDF(tfidf.WithNGramRange(min, max))
+	_, err = t.UpsertDocs(context.Background(), docs)
+	if err != nil {
+		return err
+	}
+	pruned := t.Snapshot()
+	pruned.Upserts = s.Upserts
+	pruned.Growth = s.Growth
+	pruned.Classifier = s.Classifier
+
+	store, err := openStore(*output)
+	if err != nil {
+		return err
+	}
+	err = store.Save(pruned)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("dropped %d of %d words and %d emptied docs, %d docs and %d words left\n",
+		dropped, len(df), emptied, pruned.DocCount, pruned.WordCount)
+	return nil
+}
+
+func docWords(doc *tfidf.Doc) []string {
+	words := doc.Words
+	for _, fw := range doc.Fields {
+		words = append(words[:len(words):len(words)], fw...)
+	}
+	return words
+}
+
+func filterWords(words []string, keep func(string) bool) []string {
+	res := make([]string, 0, len(words))
+	for _, w := range words {
+		if keep(w) {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+func runConvert(args []string) error {
+	fs := newFlagSet("convert")
+	spec := storeFlag(fs, "store", "snapshot to convert")
+	output := storeFlag(fs, "o", "where the snapshot is saved")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return errors.New("-o is required")
+	}
+	s, err := loadSnapshot(*spec)
+	if err != nil {
+		return err
+	}
+	store, err := openStore(*output)
+	if err != nil {
+		return err
+	}
+	err = store.Save(s)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("converted %d docs and %d words\n", len(s.Docs), len(s.Words))
+	return nil
+}
+
+func runFsck(args []string) error {
+	fs := newFlagSet("fsck")
+	spec := storeFlag(fs, "store", "snapshot to check")
+	output := storeFlag(fs, "o", "where the repaired snapshot is saved, defaults to -store")
+	repair := fs.Bool("repair", false, "save a repaired snapshot")
+	lsaFile := fs.String("lsa", "", "lsa model to check against the word indexes")
+	ngramMin, ngramMax := ngramFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = *spec
+	}
+	s, err := loadSnapshot(*spec)
+	if err != nil {
+		return err
+	}
+	min, max, err := ngramRange(s, *ngramMin, *ngramMax)
+	if err != nil {
+		return err
+	}
+
+	var model *tfidf.LSAModel
+	if *lsaFile != "" {
+		model, err = tfidf.ReadLSA(*lsaFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	t := tfidf.NewTFIDF(tfidf.WithNGramRange(min, max))
+	repaired, issues := t.RepairSnapshot(s)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	stale := false
+	if model != nil {
+		for _, issue := range tfidf.CheckLSA(s, model) {
+			fmt.Println(issue)
+			stale = true
+		}
+	}
+	if unused := t.UnusedWords(s); len(unused) > 0 {
+		fmt.Printf("%d of %d words are used by no doc, prune drops them\n", len(unused), len(s.Words))
+	}
+	if len(issues) == 0 {
+		if stale {
+			return errors.New("lsa model is stale, refit it by POST /lsa/fit")
+		}
+		fmt.Printf("ok, %d docs and %d words\n", len(s.Docs), len(s.Words))
+		return nil
+	}
+	if !*repair {
+		return fmt.Errorf("%d issues found, rerun with -repair to fix them", len(issues))
+	}
+
+	store, err := openStore(*output)
+	if err != nil {
+		return err
+	}
+	err = store.Save(repaired)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("repaired %d issues, %d docs and %d words saved\n", len(issues), repaired.DocCount, repaired.WordCount)
+	if model != nil && len(tfidf.CheckLSA(repaired, model)) > 0 {
+		fmt.Println("word indexes changed, refit the lsa model by POST /lsa/fit")
+	}
+	return nil
+}
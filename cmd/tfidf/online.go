@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sudalight/tools/pkg/tfidf"
+	"github.com/Sudalight/tools/pkg/tfidf/client"
+)
+
+const exportPageSize = 1000
+
+func serverFlags(fs *flag.FlagSet) (server *string, timeout *time.Duration) {
+	addr := os.Getenv("TFIDF_SERVER")
+	if addr == "" {
+		addr = "http://localhost:12345"
+	}
+	server = fs.String("server", addr, "base url of tfidf-server")
+	timeout = fs.Duration("timeout", time.Minute, "timeout of the whole command")
+	return server, timeout
+}
+
+func connect(server string, timeout time.Duration) (*client.Client, context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return client.New(server), ctx, cancel
+}
+
+// readDocs reads a JSON array or object from .json files, one doc per line
+// from .jsonl files and the whole content as text of a doc named by the path otherwise
+func readDocs(filename string) ([]tfidf.Doc, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", "-":
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(strings.TrimSpace(string(data)))
+		if len(data) > 0 && data[0] == '[' {
+			docs := make([]tfidf.Doc, 0)
+			return docs, json.Unmarshal(data, &docs)
+		}
+		doc := tfidf.Doc{}
+		return []tfidf.Doc{doc}, json.Unmarshal(data, &doc)
+	case ".jsonl", ".ndjson":
+		docs := make([]tfidf.Doc, 0)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			doc := tfidf.Doc{}
+			err := json.Unmarshal(scanner.Bytes(), &doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d, %s", filename, line, err.Error())
+			}
+			docs = append(docs, doc)
+		}
+		return docs, scanner.Err()
+	default:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return []tfidf.Doc{{ID: filename, Text: string(data)}}, nil
+	}
+}
+
+func readDoc(filename string) (tfidf.Doc, error) {
+	docs, err := readDocs(filename)
+	if err != nil {
+		return tfidf.Doc{}, err
+	}
+	if len(docs) != 1 {
+		return tfidf.Doc{}, fmt.Errorf("%s has %d docs, expected 1", filename, len(docs))
+	}
+	return docs[0], nil
+}
+
+func runUpsert(args []string) error {
+	fs := newFlagSet("upsert")
+	server, timeout := serverFlags(fs)
+	batch := fs.Int("batch", 500, "docs per request")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no files to upsert")
+	}
+
+	docs := make([]tfidf.Doc, 0)
+	for _, filename := range fs.Args() {
+		d, err := readDocs(filename)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, d...)
+	}
+	if err := tfidf.ValidateDocs(docs); err != nil {
+		return err
+	}
+
+	c, ctx, cancel := connect(*server, *timeout)
+	defer cancel()
+	n, err := c.UpsertDocsInBatches(ctx, docs, *batch)
+	fmt.Printf("upserted %d of %d docs\n", n, len(docs))
+	return err
+}
+
+func runVector(args []string) error {
+	fs := newFlagSet("vector")
+	server, timeout := serverFlags(fs)
+	explain := fs.Bool("explain", false, "explain every value")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("one doc file is required")
+	}
+	doc, err := readDoc(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	c, ctx, cancel := connect(*server, *timeout)
+	defer cancel()
+	var vec []*tfidf.WordTFIDF
+	if *explain {
+		vec, err = c.ExplainDocVector(ctx, doc)
+	} else {
+		vec, err = c.GetDocVector(ctx, doc)
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(vec)
+}
+
+func runKeywords(args []string) error {
+	fs := newFlagSet("keywords")
+	server, timeout := serverFlags(fs)
+	k := fs.Int("k", 10, "number of keywords")
+	id := fs.String("id", "", "id of a stored doc, which is left unchanged")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	c, ctx, cancel := connect(*server, *timeout)
+	defer cancel()
+	// explanations carry words, vectors only carry indices
+	var vec []*tfidf.WordTFIDF
+	switch {
+	case *id != "" && fs.NArg() == 0:
+		vec, err = c.StoredDocVector(ctx, *id, true)
+	case *id == "" && fs.NArg() == 1:
+		var doc tfidf.Doc
+		doc, err = readDoc(fs.Arg(0))
+		if err == nil {
+			vec, err = c.ExplainDocVector(ctx, doc)
+		}
+	default:
+		return errors.New("either -id or one doc file is required")
+	}
+	if err != nil {
+		return err
+	}
+	weights := make(map[string]float64)
+	for _, v := range vec {
+		weights[v.Explanation.Word] += v.Value
+	}
+	words := make([]string, 0, len(weights))
+	for w := range weights {
+		words = append(words, w)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if weights[words[i]] == weights[words[j]] {
+			return words[i] < words[j]
+		}
+		return weights[words[i]] > weights[words[j]]
+	})
+	if len(words) > *k {
+		words = words[:*k]
+	}
+	for _, w := range words {
+		fmt.Printf("%s\t%.6f\n", w, weights[w])
+	}
+	return nil
+}
+
+func runStats(args []string) error {
+	fs := newFlagSet("stats")
+	server, timeout := serverFlags(fs)
+	analytics := fs.Bool("analytics", false, "print analytics instead of counts")
+	top := fs.Int("top", tfidf.DefaultAnalyticsTop, "top and bottom words of analytics")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, ctx, cancel := connect(*server, *timeout)
+	defer cancel()
+	if *analytics {
+		res, err := c.Analytics(ctx, *top, tfidf.DefaultAnalyticsBuckets)
+		if err != nil {
+			return err
+		}
+		return printJSON(res)
+	}
+	res, err := c.Statistics(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(res)
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	server, timeout := serverFlags(fs)
+	output := fs.String("o", "-", "output file, - writes stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+
+	c, ctx, cancel := connect(*server, *timeout)
+	defer cancel()
+	for offset := 0; ; offset += exportPageSize {
+		page, err := c.ListDocs(ctx, offset, exportPageSize, tfidf.SortByIndex)
+		if err != nil {
+			return err
+		}
+		for i := range page.Docs {
+			if err := enc.Encode(page.Docs[i]); err != nil {
+				return err
+			}
+		}
+		if offset+len(page.Docs) >= page.Total || len(page.Docs) == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package tfidf
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultAnalyticsTop     = 20
+	DefaultAnalyticsBuckets = 10
+
+	// a growth point is recorded every growthInterval upserts at least,
+	// the interval widens with the corpus to keep about maxGrowthPoints points
+	growthInterval  = 100
+	maxGrowthPoints = 256
+)
+
+// GrowthPoint samples the corpus size after a number of upserts
+type GrowthPoint struct {
+	Time      time.Time `json:"time"`
+	Upserts   int       `json:"upserts"`
+	DocCount  int       `json:"doc_count"`
+	WordCount int       `json:"word_count"`
+}
+
+type Analytics struct {
+	DocCount  int `json:"doc_count"`
+	WordCount int `json:"word_count"`
+	Upserts   int `json:"upserts"`
+	// words ranked by document frequency, ties by index, words in no doc
+	// are only counted by UnusedWordCount
+	TopWords        []WordInfo `json:"top_words"`
+	BottomWords     []WordInfo `json:"bottom_words"`
+	UnusedWordCount int        `json:"unused_word_count"`
+	// share of used words occurring in exactly one doc
+	SingletonRatio float64       `json:"singleton_ratio"`
+	DocLength      LengthStats   `json:"doc_length"`
+	Growth         []GrowthPoint `json:"growth"`
+}
+
+// LengthStats describes doc lengths in words, fields included
+type LengthStats struct {
+	Min         int            `json:"min"`
+	Max         int            `json:"max"`
+	Avg         float64        `json:"avg"`
+	Percentiles map[string]int `json:"percentiles"`
+	Histogram   []Bucket       `json:"histogram"`
+}
+
+// Bucket counts lengths in [From, To)
+type Bucket struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Count int `json:"count"`
+}
+
+func (p *persistentData) countUpsert() {
+	defer p.Unlock()
+	p.Lock()
+	p.Upserts++
+	p.updated = true
+
+	interval := growthInterval
+	if i := p.Upserts / maxGrowthPoints; i > interval {
+		interval = i
+	}
+	if n := len(p.Growth); n > 0 && p.Upserts-p.Growth[n-1].Upserts < interval {
+		return
+	}
+	p.Growth = append(p.Growth, GrowthPoint{
+		Time:      time.Now().UTC(),
+		Upserts:   p.Upserts,
+		DocCount:  len(p.Docs),
+		WordCount: len(p.Words),
+	})
+	if len(p.Growth) > 2*maxGrowthPoints {
+		// keep every other point, the first and the last one
+		thinned := make([]GrowthPoint, 0, maxGrowthPoints+1)
+		for i := 0; i < len(p.Growth)-1; i += 2 {
+			thinned = append(thinned, p.Growth[i])
+		}
+		p.Growth = append(thinned, p.Growth[len(p.Growth)-1])
+	}
+}
+
+func docLength(doc Doc) int {
+	n := len(doc.Words)
+	for _, words := range doc.Fields {
+		n += len(words)
+	}
+	return n
+}
+
+// Analytics reports the top and bottom words by document frequency and
+// distributions of the corpus, lengths are split into the number of buckets
+func (t *TFIDF) Analytics(top, buckets int) Analytics {
+	t.pd.Lock()
+	values := make([]string, len(t.pd.Words))
+	copy(values, t.pd.Words)
+	res := Analytics{
+		Upserts: t.pd.Upserts,
+		Growth:  make([]GrowthPoint, len(t.pd.Growth)),
+	}
+	copy(res.Growth, t.pd.Growth)
+	t.pd.Unlock()
+
+	words := make([]*word, 0, len(values))
+	singletons := 0
+	for i := range values {
+		w := t.wm.getWord(values[i])
+		if w == nil {
+			continue
+		}
+		switch w.docCount() {
+		case 0:
+			res.UnusedWordCount++
+			continue
+		case 1:
+			singletons++
+		}
+		words = append(words, w)
+	}
+	if len(words) > 0 {
+		res.SingletonRatio = float64(singletons) / float64(len(words))
+	}
+
+	if top > len(words) {
+		top = len(words)
+	}
+	sort.SliceStable(words, func(i, j int) bool {
+		return words[i].docCount() < words[j].docCount()
+	})
+	res.BottomWords = make([]WordInfo, 0, top)
+	for _, w := range words[:top] {
+		res.BottomWords = append(res.BottomWords, t.wordInfo(w))
+	}
+	sort.SliceStable(words, func(i, j int) bool {
+		if words[i].docCount() != words[j].docCount() {
+			return words[i].docCount() > words[j].docCount()
+		}
+		return words[i].index < words[j].index
+	})
+	res.TopWords = make([]WordInfo, 0, top)
+	for _, w := range words[:top] {
+		res.TopWords = append(res.TopWords, t.wordInfo(w))
+	}
+
+	docs := t.storedDocs()
+	lengths := make([]int, len(docs))
+	for i := range docs {
+		lengths[i] = docLength(docs[i])
+	}
+	res.DocCount = len(docs)
+	res.WordCount = len(values)
+	res.DocLength = lengthStats(lengths, buckets)
+	return res
+}
+
+func lengthStats(lengths []int, buckets int) LengthStats {
+	res := LengthStats{
+		Percentiles: make(map[string]int),
+		Histogram:   make([]Bucket, 0, buckets),
+	}
+	if len(lengths) == 0 {
+		return res
+	}
+	sort.Ints(lengths)
+	res.Min, res.Max = lengths[0], lengths[len(lengths)-1]
+	sum := 0
+	for _, l := range lengths {
+		sum += l
+	}
+	res.Avg = float64(sum) / float64(len(lengths))
+	for _, p := range []int{50, 90, 95, 99} {
+		// nearest rank
+		rank := int(math.Ceil(float64(p) / 100 * float64(len(lengths))))
+		res.Percentiles["p"+strconv.Itoa(p)] = lengths[rank-1]
+	}
+
+	width := (res.Max - res.Min + buckets) / buckets
+	for from := res.Min; from <= res.Max; from += width {
+		res.Histogram = append(res.Histogram, Bucket{From: from, To: from + width})
+	}
+	for _, l := range lengths {
+		res.Histogram[(l-res.Min)/width].Count++
+	}
+	return res
+}
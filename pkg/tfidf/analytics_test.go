@@ -0,0 +1,49 @@
+package tfidf
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLengthStats(t *testing.T) {
+	stats := lengthStats([]int{10, 1, 2, 3, 4, 5, 6, 7, 8, 9}, 3)
+	expected := LengthStats{
+		Min: 1, Max: 10, Avg: 5.5,
+		Percentiles: map[string]int{"p50": 5, "p90": 9, "p95": 10, "p99": 10},
+		Histogram:   []Bucket{{From: 1, To: 5, Count: 4}, {From: 5, To: 9, Count: 4}, {From: 9, To: 13, Count: 2}},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+	// equal lengths fill one bucket of width 1
+	stats = lengthStats([]int{5, 5}, 4)
+	if !reflect.DeepEqual(stats.Histogram, []Bucket{{From: 5, To: 6, Count: 2}}) {
+		t.Fatalf("unexpected histogram %+v", stats.Histogram)
+	}
+	if stats = lengthStats(nil, 4); len(stats.Histogram) != 0 {
+		t.Fatalf("expected no buckets without docs, got %+v", stats)
+	}
+}
+
+func TestAnalytics(t *testing.T) {
+	tfidf := NewTFIDF()
+	if _, err := tfidf.UpsertDocs(context.Background(), testDocs()); err != nil {
+		t.Fatal(err)
+	}
+	tfidf.DeleteDoc("3")
+
+	res := tfidf.Analytics(2, 2)
+	if res.DocCount != 2 || res.WordCount != 5 || res.Upserts != 3 || res.UnusedWordCount != 1 {
+		t.Fatalf("unexpected counts %+v", res)
+	}
+	if res.TopWords[0].Word != "banana" || res.TopWords[1].Word != "apple" || res.BottomWords[0].Word != "apple" {
+		t.Fatalf("unexpected top and bottom words %+v, %+v", res.TopWords, res.BottomWords)
+	}
+	if res.SingletonRatio != 0.75 || !reflect.DeepEqual(res.DocLength.Histogram, []Bucket{{From: 3, To: 4, Count: 2}}) {
+		t.Fatalf("unexpected distributions %+v", res)
+	}
+	if len(res.Growth) != 1 || res.Growth[0].Upserts != 1 {
+		t.Fatalf("expected the first upsert sampled, got %+v", res.Growth)
+	}
+}
@@ -0,0 +1,298 @@
+package tfidf
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultANNTables = 16
+	defaultANNBits   = 8
+	defaultANNSeed   = 1
+
+	maxANNBits = 64
+)
+
+// ANNOptions shape the random projection LSH index of TF-IDF vectors, every
+// table hashes a doc by the signs of its projections onto Bits hyperplanes.
+// More bits make buckets smaller, more tables find more of the true neighbours.
+type ANNOptions struct {
+	Tables int   `json:"tables"`
+	Bits   int   `json:"bits"`
+	Seed   int64 `json:"seed"`
+}
+
+// ANNSnapshot keeps the signatures of docs so that loading skips hashing
+// them, they are rehashed when the options differ from the loading TFIDF
+type ANNSnapshot struct {
+	Options    ANNOptions          `json:"options"`
+	Signatures map[string][]uint64 `json:"signatures"`
+}
+
+type ANNStats struct {
+	ANNDocs        int        `json:"ann_docs"`
+	ANNBuckets     int        `json:"ann_buckets"`
+	LastANNRebuild *time.Time `json:"last_ann_rebuild,omitempty"`
+}
+
+// WithANN sets the LSH index options, zero values keep defaults and bits
+// are capped at 64
+func WithANN(opts ANNOptions) Option {
+	return func(t *TFIDF) {
+		if opts.Tables > 0 {
+			t.ann.opts.Tables = opts.Tables
+		}
+		if opts.Bits > maxANNBits {
+			opts.Bits = maxANNBits
+		}
+		if opts.Bits > 0 {
+			t.ann.opts.Bits = opts.Bits
+		}
+		if opts.Seed != 0 {
+			t.ann.opts.Seed = opts.Seed
+		}
+		t.ann.clear()
+	}
+}
+
+// annIndex buckets docs by their signature in every table. Signatures are
+// computed with the IDF of the time the doc is upserted, which drifts as
+// the corpus grows, candidates are ranked by exact cosine similarity anyway.
+type annIndex struct {
+	sync.Mutex
+	opts        ANNOptions
+	sigs        map[string][]uint64
+	buckets     []map[uint64]set
+	lastRebuild time.Time
+}
+
+func newANNIndex() *annIndex {
+	a := &annIndex{
+		opts: ANNOptions{
+			Tables: defaultANNTables,
+			Bits:   defaultANNBits,
+			Seed:   defaultANNSeed,
+		},
+	}
+	a.clear()
+	return a
+}
+
+func (a *annIndex) clear() {
+	a.sigs = make(map[string][]uint64)
+	a.buckets = make([]map[uint64]set, a.opts.Tables)
+	for i := range a.buckets {
+		a.buckets[i] = make(map[uint64]set)
+	}
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// project returns projections of the vector onto the hyperplanes of the
+// table, components of hyperplanes are ±1 drawn from the seed by word index
+// so that they never have to be stored or grown with the vocabulary
+func (a *annIndex) project(vec sparseVector, table int) []float64 {
+	res := make([]float64, a.opts.Bits)
+	for index, x := range vec {
+		h := splitMix64(uint64(a.opts.Seed) ^ splitMix64(uint64(table)<<32|uint64(uint32(index))))
+		for b := range res {
+			if h&(1<<uint(b)) != 0 {
+				res[b] += x
+			} else {
+				res[b] -= x
+			}
+		}
+	}
+	return res
+}
+
+func signature(projections []float64) uint64 {
+	sig := uint64(0)
+	for b, p := range projections {
+		if p > 0 {
+			sig |= 1 << uint(b)
+		}
+	}
+	return sig
+}
+
+func (a *annIndex) signatures(vec sparseVector) []uint64 {
+	sigs := make([]uint64, a.opts.Tables)
+	for i := range sigs {
+		sigs[i] = signature(a.project(vec, i))
+	}
+	return sigs
+}
+
+func (a *annIndex) set(id string, vec sparseVector) {
+	if a == nil {
+		return
+	}
+	sigs := a.signatures(vec)
+	defer a.Unlock()
+	a.Lock()
+	a.put(id, sigs)
+}
+
+// put replaces the signatures of the doc, the caller holds the lock
+func (a *annIndex) put(id string, sigs []uint64) {
+	a.unlink(id)
+	a.sigs[id] = sigs
+	for i, sig := range sigs {
+		if a.buckets[i][sig] == nil {
+			a.buckets[i][sig] = make(set)
+		}
+		a.buckets[i][sig].set(id)
+	}
+}
+
+// unlink removes the doc from its buckets, the caller holds the lock
+func (a *annIndex) unlink(id string) {
+	for i, sig := range a.sigs[id] {
+		a.buckets[i][sig].del(id)
+		if len(a.buckets[i][sig]) == 0 {
+			delete(a.buckets[i], sig)
+		}
+	}
+	delete(a.sigs, id)
+}
+
+func (a *annIndex) remove(id string) {
+	if a == nil {
+		return
+	}
+	defer a.Unlock()
+	a.Lock()
+	a.unlink(id)
+}
+
+// candidates collects docs in the bucket of the query in the first tables,
+// plus probes buckets per table found by flipping the bits the query is
+// closest to the hyperplanes of, one at a time
+func (a *annIndex) candidates(query sparseVector, tables, probes int) set {
+	res := make(set)
+	for i := 0; i < tables; i++ {
+		projections := a.project(query, i)
+		sig := signature(projections)
+		bits := make([]int, len(projections))
+		for b := range bits {
+			bits[b] = b
+		}
+		sort.Slice(bits, func(x, y int) bool {
+			return math.Abs(projections[bits[x]]) < math.Abs(projections[bits[y]])
+		})
+		probed := []uint64{sig}
+		for _, b := range bits[:probes] {
+			probed = append(probed, sig^(1<<uint(b)))
+		}
+
+		a.Lock()
+		for _, s := range probed {
+			for id := range a.buckets[i][s] {
+				res.set(id)
+			}
+		}
+		a.Unlock()
+	}
+	return res
+}
+
+func (a *annIndex) snapshot() *ANNSnapshot {
+	defer a.Unlock()
+	a.Lock()
+	s := &ANNSnapshot{
+		Options:    a.opts,
+		Signatures: make(map[string][]uint64, len(a.sigs)),
+	}
+	for id, sigs := range a.sigs {
+		s.Signatures[id] = sigs
+	}
+	return s
+}
+
+// validateSearch checks the recall parameters of an approximate search
+func (a *annIndex) validateSearch(req SearchRequest) error {
+	if req.Tables < 0 || req.Tables > a.opts.Tables {
+		return invalidf("tables", "tables should be 0 to %d", a.opts.Tables)
+	}
+	if req.Probes < 0 || req.Probes > a.opts.Bits {
+		return invalidf("probes", "probes should be 0 to %d", a.opts.Bits)
+	}
+	return nil
+}
+
+// loadANN hashes docs of the snapshot, reusing stored signatures made with
+// the same options, the caller holds the lock
+func (t *TFIDF) loadANN(s *ANNSnapshot) {
+	defer t.ann.Unlock()
+	t.ann.Lock()
+	t.ann.clear()
+	t.ann.lastRebuild = time.Time{}
+	for i := range t.pd.Docs {
+		id := t.pd.Docs[i].ID
+		if s != nil && s.Options == t.ann.opts {
+			if sigs, ok := s.Signatures[id]; ok && len(sigs) == t.ann.opts.Tables {
+				t.ann.put(id, sigs)
+				continue
+			}
+		}
+		t.ann.put(id, t.ann.signatures(t.docVector(t.pd.Docs[i])))
+	}
+}
+
+// RebuildANN rehashes every doc with the current IDF, docs are hashed under
+// the lock one at a time so that concurrent upserts are never overwritten
+func (t *TFIDF) RebuildANN(ctx context.Context) (ANNStats, error) {
+	for _, doc := range t.storedDocs() {
+		if err := ctx.Err(); err != nil {
+			return ANNStats{}, err
+		}
+		t.Lock()
+		if stored := t.getDoc(doc.ID); stored != nil {
+			t.ann.set(doc.ID, t.docVector(*stored))
+		}
+		t.Unlock()
+	}
+
+	t.ann.Lock()
+	t.ann.lastRebuild = time.Now().UTC()
+	t.ann.Unlock()
+	t.pd.Lock()
+	t.pd.updated = true
+	t.pd.Unlock()
+	return t.ANNStats(), nil
+}
+
+func (t *TFIDF) ANNStats() ANNStats {
+	defer t.ann.Unlock()
+	t.ann.Lock()
+	res := ANNStats{ANNDocs: len(t.ann.sigs)}
+	for i := range t.ann.buckets {
+		res.ANNBuckets += len(t.ann.buckets[i])
+	}
+	if !t.ann.lastRebuild.IsZero() {
+		last := t.ann.lastRebuild
+		res.LastANNRebuild = &last
+	}
+	return res
+}
+
+func (s *Server) RebuildANN(ctx *gin.Context) {
+	res, err := s.engine().RebuildANN(ctx.Request.Context())
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
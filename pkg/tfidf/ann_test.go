@@ -0,0 +1,132 @@
+package tfidf
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// topicDocs draws most words of every doc from one of the topics so that
+// docs of a topic are neighbours, the rest follow Zipf's law as in text so
+// that common words are shared by most docs
+func topicDocs(rnd *rand.Rand, n, topics, vocab, length int) []Doc {
+	zipf := rand.NewZipf(rnd, 1.1, 1, uint64(vocab*topics))
+	docs := make([]Doc, n)
+	for i := range docs {
+		topic := rnd.Intn(topics)
+		words := make([]string, length)
+		for j := range words {
+			if rnd.Float64() < 0.6 {
+				words[j] = fmt.Sprintf("t%dw%d", topic, rnd.Intn(vocab))
+			} else {
+				words[j] = fmt.Sprintf("w%d", zipf.Uint64())
+			}
+		}
+		docs[i] = Doc{ID: fmt.Sprintf("doc%d", i), Words: words}
+	}
+	return docs
+}
+
+func searchIDs(t testing.TB, tfidf *TFIDF, req SearchRequest) []string {
+	t.Helper()
+	hits, err := tfidf.Search(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(hits))
+	for i := range hits {
+		ids[i] = hits[i].ID
+	}
+	return ids
+}
+
+// recall is the share of exact hits found by the approximate search
+func recall(t testing.TB, tfidf *TFIDF, queries []Doc, tables, probes int) float64 {
+	found, total := 0, 0
+	for _, q := range queries {
+		exact := searchIDs(t, tfidf, SearchRequest{Doc: q, Limit: 10})
+		approx := make(set)
+		for _, id := range searchIDs(t, tfidf, SearchRequest{Doc: q, Limit: 10, Approximate: true, Tables: tables, Probes: probes}) {
+			approx.set(id)
+		}
+		for _, id := range exact {
+			if approx.exist(id) {
+				found++
+			}
+		}
+		total += len(exact)
+	}
+	return float64(found) / float64(total)
+}
+
+func TestANNSearch(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(context.Background(), topicDocs(rnd, 500, 20, 50, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := topicDocs(rnd, 20, 20, 50, 30)
+
+	low := recall(t, tfidf, queries, 1, 0)
+	high := recall(t, tfidf, queries, defaultANNTables, 4)
+	if high < 0.5 || high <= low {
+		t.Fatalf("expected recall to grow with tables and probes, got %.2f and %.2f", low, high)
+	}
+
+	_, err = tfidf.Search(context.Background(), SearchRequest{Doc: queries[0], Approximate: true, Probes: defaultANNBits + 1})
+	if err == nil {
+		t.Fatal("expected probes beyond bits to be invalid")
+	}
+
+	tfidf.DeleteDoc("doc0")
+	if stats := tfidf.ANNStats(); stats.ANNDocs != 499 {
+		t.Fatalf("expected 499 hashed docs after delete, got %d", stats.ANNDocs)
+	}
+
+	s := tfidf.Snapshot()
+	loaded := NewTFIDF()
+	loaded.loadSnapshot(s)
+	for id, sigs := range s.ANN.Signatures {
+		if fmt.Sprint(loaded.ann.sigs[id]) != fmt.Sprint(sigs) {
+			t.Fatalf("expected stored signatures of %s to be reused", id)
+		}
+	}
+}
+
+func benchmarkSearch(b *testing.B, req SearchRequest) {
+	rnd := rand.New(rand.NewSource(1))
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(context.Background(), topicDocs(rnd, 10000, 100, 200, 50))
+	if err != nil {
+		b.Fatal(err)
+	}
+	queries := topicDocs(rnd, 100, 100, 200, 50)
+	r := 1.0
+	if req.Approximate {
+		r = recall(b, tfidf, queries[:20], req.Tables, req.Probes)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req.Doc = queries[i%len(queries)]
+		_, err := tfidf.Search(context.Background(), req)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(r, "recall")
+}
+
+func BenchmarkSearchExact(b *testing.B) {
+	benchmarkSearch(b, SearchRequest{Limit: 10})
+}
+
+func BenchmarkSearchApproximate(b *testing.B) {
+	benchmarkSearch(b, SearchRequest{Limit: 10, Approximate: true})
+}
+
+func BenchmarkSearchApproximateProbes(b *testing.B) {
+	benchmarkSearch(b, SearchRequest{Limit: 10, Approximate: true, Probes: 4})
+}
@@ -0,0 +1,173 @@
+package tfidf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const backupVersion = 1
+
+// Backup is a point-in-time copy of the corpus together with the LSA model
+type Backup struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Snapshot  *Snapshot `json:"snapshot"`
+	LSA       *LSAModel `json:"lsa,omitempty"`
+}
+
+// Backup copies the data under the lock, encoding is left to the caller so
+// that writes are only blocked while copying
+func (t *TFIDF) Backup() *Backup {
+	t.Lock()
+	s := t.snapshot()
+	t.Unlock()
+	return &Backup{
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC(),
+		Snapshot:  s,
+		LSA:       t.lsa.get(),
+	}
+}
+
+// WriteBackup streams a backup as JSON
+func (t *TFIDF) WriteBackup(w io.Writer) error {
+	return json.NewEncoder(w).Encode(t.Backup())
+}
+
+// ReadBackup decodes and checks a backup written by WriteBackup
+func ReadBackup(r io.Reader) (*Backup, error) {
+	b := &Backup{}
+	err := json.NewDecoder(r).Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if b.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+	if b.Snapshot == nil {
+		return nil, fmt.Errorf("backup has no snapshot")
+	}
+	if len(b.Snapshot.WordOrders) > len(b.Snapshot.Words) {
+		return nil, fmt.Errorf("backup has %d word orders for %d words", len(b.Snapshot.WordOrders), len(b.Snapshot.Words))
+	}
+	if m := b.LSA; m != nil {
+		if len(m.Terms) != len(m.Vectors) || len(m.Singular) != m.Components {
+			return nil, fmt.Errorf("malformed lsa model in backup")
+		}
+		m.index()
+	}
+	return b, nil
+}
+
+// BackupStore reads and writes snapshots as backup files, the LSA model of
+// a backup is left out
+type BackupStore struct {
+	Filename string
+	FileMode os.FileMode
+}
+
+func (b *BackupStore) Load() (*Snapshot, error) {
+	f, err := os.Open(b.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	backup, err := ReadBackup(f)
+	if err != nil {
+		return nil, err
+	}
+	return backup.Snapshot, nil
+}
+
+func (b *BackupStore) Save(s *Snapshot) error {
+	data, err := json.Marshal(&Backup{
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC(),
+		Snapshot:  s,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.Filename, data, b.FileMode)
+}
+
+// restore loads the backup into an empty TFIDF, everything is marked
+// updated to be written by the next save. Backups with integrity issues
+// are rejected, they can be repaired offline by `tfidf fsck -repair`.
+func (t *TFIDF) restore(b *Backup) error {
+	if issues := t.CheckSnapshot(b.Snapshot); len(issues) > 0 {
+		logIssues(issues)
+		return invalidf("snapshot", "backup has %d integrity issues, the first is %s", len(issues), issues[0])
+	}
+	t.Lock()
+	t.loadSnapshot(b.Snapshot)
+	t.pd.updated = true
+	t.Unlock()
+	if b.LSA != nil {
+		t.lsa.set(b.LSA)
+	}
+	return nil
+}
+
+// Restore swaps the served TFIDF by a new one loaded from the backup, the
+// swap waits for handlers changing docs, other requests in flight finish
+// on the old one
+func (s *Server) Restore(r io.Reader) error {
+	b, err := ReadBackup(r)
+	if err != nil {
+		return err
+	}
+	t := NewTFIDF(s.opts...)
+	err = t.restore(b)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	// subscribers of the change feed keep listening across restores
+	t.feed = s.tfidf.feed
+	s.tfidf = t
+	s.mu.Unlock()
+	t.feed.publish(Change{Op: OpRestore})
+	return nil
+}
+
+func (s *Server) WriteBackup(w io.Writer) error {
+	return s.engine().WriteBackup(w)
+}
+
+func (s *Server) GetBackup(ctx *gin.Context) {
+	b := s.engine().Backup()
+	ctx.Header("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="tfidf-backup-%s.json"`, b.CreatedAt.Format("20060102T150405Z")))
+	ctx.Header("Content-Type", "application/json")
+	ctx.Status(http.StatusOK)
+	err := json.NewEncoder(ctx.Writer).Encode(b)
+	if err != nil {
+		ctx.Error(err)
+	}
+}
+
+func (s *Server) PostRestore(ctx *gin.Context) {
+	err := s.Restore(ctx.Request.Body)
+	if err != nil {
+		if abortIfDone(ctx, err) {
+			return
+		}
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			abortInvalid(ctx, err)
+			return
+		}
+		abortWithError(ctx, http.StatusBadRequest, ErrCodeInvalidJSON, err)
+		return
+	}
+	s.GetStatistics(ctx)
+}
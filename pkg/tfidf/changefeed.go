@@ -0,0 +1,320 @@
+package tfidf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	// OpRestore means the whole corpus was replaced, subscribers should resync
+	OpRestore = "restore"
+
+	defaultChangeFeedSize = 10000
+	changeHeartbeat       = 15 * time.Second
+	// changes are buffered and written to the change log after the delay
+	changeLogFlushDelay = time.Second
+)
+
+// Change describes one doc write, words are terms added to or removed from the doc
+type Change struct {
+	Seq     uint64    `json:"seq"`
+	DocID   string    `json:"doc_id,omitempty"`
+	Op      string    `json:"op"`
+	Time    time.Time `json:"time"`
+	Added   []string  `json:"added,omitempty"`
+	Removed []string  `json:"removed,omitempty"`
+}
+
+// ChangePage lists changes after a sequence number, Reset means changes
+// have been dropped from the feed since then and subscribers should resync
+type ChangePage struct {
+	Seq     uint64   `json:"seq"`
+	Reset   bool     `json:"reset"`
+	Changes []Change `json:"changes"`
+}
+
+// WithChangeFeedSize sets how many recent changes are kept in memory
+func WithChangeFeedSize(n int) Option {
+	return func(t *TFIDF) {
+		if n > 0 {
+			t.feed = newChangeFeed(n)
+		}
+	}
+}
+
+// changeFeed is a ring buffer of recent changes, the change of sequence s
+// is kept in buf[(s-1)%len(buf)]
+type changeFeed struct {
+	sync.Mutex
+	buf []Change
+	n   int
+	seq uint64
+	// closed and replaced by every publish
+	notify chan struct{}
+
+	log      *os.File
+	w        *bufio.Writer
+	filename string
+	mode     os.FileMode
+	// changes in the log, which is compacted to the changes kept once twice as many
+	lines    int
+	flushing bool
+}
+
+func newChangeFeed(size int) *changeFeed {
+	return &changeFeed{
+		buf:    make([]Change, size),
+		notify: make(chan struct{}),
+	}
+}
+
+func (f *changeFeed) push(c Change) {
+	f.buf[(c.Seq-1)%uint64(len(f.buf))] = c
+	f.seq = c.Seq
+	if f.n < len(f.buf) {
+		f.n++
+	}
+}
+
+func (f *changeFeed) publish(c Change) {
+	if f == nil {
+		return
+	}
+	defer f.Unlock()
+	f.Lock()
+	c.Seq = f.seq + 1
+	c.Time = time.Now().UTC()
+	f.push(c)
+	if f.w != nil {
+		data, err := json.Marshal(c)
+		if err == nil {
+			_, err = f.w.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Println("failed to append change log,", err)
+		}
+		f.lines++
+		if !f.flushing {
+			f.flushing = true
+			time.AfterFunc(changeLogFlushDelay, func() {
+				if err := f.flush(); err != nil {
+					log.Println("failed to write change log,", err)
+				}
+			})
+		}
+	}
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// since returns changes after seq and a channel closed by the next publish
+func (f *changeFeed) since(seq uint64) (ChangePage, <-chan struct{}) {
+	defer f.Unlock()
+	f.Lock()
+	page := ChangePage{
+		Seq:     f.seq,
+		Changes: make([]Change, 0),
+	}
+	oldest := f.seq - uint64(f.n) + 1
+	if seq > f.seq || seq+1 < oldest {
+		// the feed restarted or dropped changes
+		page.Reset = true
+		seq = oldest - 1
+	}
+	for s := seq + 1; s <= f.seq; s++ {
+		page.Changes = append(page.Changes, f.buf[(s-1)%uint64(len(f.buf))])
+	}
+	return page, f.notify
+}
+
+// open replays the change log into the ring buffer and appends to it from then on
+func (f *changeFeed) open(filename string, mode os.FileMode) error {
+	defer f.Unlock()
+	f.Lock()
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lines := 0
+	for ; scanner.Scan(); lines++ {
+		c := Change{}
+		err = json.Unmarshal(scanner.Bytes(), &c)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("invalid change at line %d of %s, %s", lines+1, filename, err.Error())
+		}
+		f.push(c)
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return err
+	}
+	if f.log != nil {
+		f.w.Flush()
+		f.log.Close()
+	}
+	f.log = file
+	f.w = bufio.NewWriter(file)
+	f.filename = filename
+	f.mode = mode
+	f.lines = lines
+	if lines > len(f.buf) {
+		return f.compact()
+	}
+	return nil
+}
+
+// flush writes buffered changes, compacting the log once it holds twice
+// as many changes as kept in memory
+func (f *changeFeed) flush() error {
+	if f == nil {
+		return nil
+	}
+	defer f.Unlock()
+	f.Lock()
+	f.flushing = false
+	if f.w == nil {
+		return nil
+	}
+	if f.lines >= 2*len(f.buf) {
+		return f.compact()
+	}
+	return f.w.Flush()
+}
+
+// compact replaces the log by the changes kept in memory, which include
+// the buffered ones, the caller holds the lock
+func (f *changeFeed) compact() error {
+	var b bytes.Buffer
+	for s := f.seq - uint64(f.n) + 1; s <= f.seq; s++ {
+		data, err := json.Marshal(f.buf[(s-1)%uint64(len(f.buf))])
+		if err != nil {
+			return err
+		}
+		b.Write(append(data, '\n'))
+	}
+	err := writeFileAtomic(f.filename, b.Bytes(), f.mode)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND, f.mode)
+	if err != nil {
+		return err
+	}
+	f.log.Close()
+	f.log = file
+	f.w = bufio.NewWriter(file)
+	f.lines = f.n
+	return nil
+}
+
+func uniqueWords(words []string) []string {
+	s := make(set)
+	for i := range words {
+		s.set(words[i])
+	}
+	return s.members()
+}
+
+func changedWords(oldWords, newWords []string) (added, removed []string) {
+	added, removed = wordsDiff(uniqueWords(oldWords), uniqueWords(newWords))
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// OpenChangeLog persists the change feed to a JSON lines file, changes in the
+// file are replayed so that sequence numbers survive restarts. Changes are
+// written a second after they are published, the log keeps at most twice
+// as many changes as the feed.
+func (t *TFIDF) OpenChangeLog(filename string) error {
+	return t.feed.open(filename, t.fileMode)
+}
+
+// FlushChangeLog writes changes buffered for the change log, e.g. before exit
+func (t *TFIDF) FlushChangeLog() error {
+	return t.feed.flush()
+}
+
+// Changes lists recent changes after seq
+func (t *TFIDF) Changes(since uint64) ChangePage {
+	page, _ := t.feed.since(since)
+	return page
+}
+
+func (s *Server) OpenChangeLog(filename string) error {
+	return s.engine().OpenChangeLog(filename)
+}
+
+func (s *Server) FlushChangeLog() error {
+	return s.engine().FlushChangeLog()
+}
+
+// GetChanges streams changes as server-sent events when asked by the Accept
+// header, otherwise lists changes after `since` as JSON. Streams resume from
+// the Last-Event-ID header.
+func (s *Server) GetChanges(ctx *gin.Context) {
+	since := uint64(0)
+	v := ctx.Query("since")
+	if id := ctx.GetHeader("Last-Event-ID"); id != "" {
+		v = id
+	}
+	if v != "" {
+		var err error
+		since, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			abortInvalid(ctx, invalidf("since", "invalid since %q", v))
+			return
+		}
+	}
+
+	feed := s.engine().feed
+	if !strings.Contains(ctx.GetHeader("Accept"), "text/event-stream") {
+		page, _ := feed.since(since)
+		ctx.JSON(http.StatusOK, page)
+		return
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Status(http.StatusOK)
+	heartbeat := time.NewTicker(changeHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		page, notify := feed.since(since)
+		if page.Reset {
+			fmt.Fprintf(ctx.Writer, "event: reset\ndata: {\"seq\":%d}\n\n", page.Seq)
+		}
+		for _, c := range page.Changes {
+			data, _ := json.Marshal(c)
+			fmt.Fprintf(ctx.Writer, "id: %d\nevent: change\ndata: %s\n\n", c.Seq, data)
+		}
+		ctx.Writer.Flush()
+		since = page.Seq
+
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(ctx.Writer, ": ping\n\n")
+		case <-notify:
+		}
+	}
+}
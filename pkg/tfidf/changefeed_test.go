@@ -0,0 +1,46 @@
+package tfidf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestChangeLogCompaction(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "changes.jsonl")
+	writer := NewTFIDF(WithChangeFeedSize(3))
+	err := writer.OpenChangeLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		_, err = writer.UpsertDocs(context.Background(), []Doc{{ID: fmt.Sprint(i), Words: []string{"a"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.FlushChangeLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Fatalf("expected the log compacted to 3 changes, got %d", lines)
+	}
+
+	reader := NewTFIDF(WithChangeFeedSize(3))
+	err = reader.OpenChangeLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := reader.Changes(4)
+	if page.Seq != 7 || page.Reset || len(page.Changes) != 3 || page.Changes[0].DocID != "4" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+}
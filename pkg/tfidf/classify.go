@@ -0,0 +1,487 @@
+package tfidf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	NaiveBayes = "naive_bayes"
+	Rocchio    = "rocchio"
+
+	defaultTrainFolds = 5
+	defaultNBAlpha    = 1.0
+)
+
+var ErrClassifierNotTrained = errors.New("classifier is not trained, train it by POST /train")
+
+type TrainOptions struct {
+	// naive_bayes by default, or rocchio
+	Algorithm string `json:"algorithm,omitempty"`
+	// folds of cross validation, 5 by default, 1 skips it
+	Folds int `json:"folds,omitempty"`
+	// additive smoothing of naive bayes, 1 by default
+	Alpha float64 `json:"alpha,omitempty"`
+	// seed shuffling docs into folds
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// ClassifierModel is trained from labeled docs, a doc with several labels
+// counts once for each of them. Terms are n-grams of all fields.
+type ClassifierModel struct {
+	Algorithm string    `json:"algorithm"`
+	Labels    []string  `json:"labels"`
+	DocCount  int       `json:"doc_count"`
+	TrainedAt time.Time `json:"trained_at"`
+
+	// naive bayes: terms of training docs, log priors of labels, log
+	// likelihoods of terms seen with labels and of terms never seen with them.
+	// Terms outside the vocabulary are skipped in predictions.
+	Vocabulary []string                      `json:"vocabulary,omitempty"`
+	LogPriors  map[string]float64            `json:"log_priors,omitempty"`
+	LogProbs   map[string]map[string]float64 `json:"log_probs,omitempty"`
+	LogUnknown map[string]float64            `json:"log_unknown,omitempty"`
+
+	// rocchio: L2 normalized mean TF-IDF vectors of labels
+	Centroids map[string]map[string]float64 `json:"centroids,omitempty"`
+
+	vocabulary set
+}
+
+// index builds the vocabulary lookup before the model is shared, models
+// trained before the vocabulary was stored fall back to the terms of labels
+func (m *ClassifierModel) index() {
+	if m == nil {
+		return
+	}
+	m.vocabulary = make(set, len(m.Vocabulary))
+	for _, term := range m.Vocabulary {
+		m.vocabulary.set(term)
+	}
+	if len(m.Vocabulary) == 0 {
+		for _, probs := range m.LogProbs {
+			for term := range probs {
+				m.vocabulary.set(term)
+			}
+		}
+	}
+}
+
+type LabelProbability struct {
+	Label       string  `json:"label"`
+	Probability float64 `json:"probability"`
+}
+
+type Classification struct {
+	ID    string `json:"id,omitempty"`
+	Label string `json:"label"`
+	// probabilities of all labels sorted by descending probability
+	Probabilities []LabelProbability `json:"probabilities"`
+}
+
+type LabelMetrics struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	Support   int     `json:"support"`
+}
+
+// CVMetrics counts a prediction as correct if the doc carries the predicted label
+type CVMetrics struct {
+	Folds    int                     `json:"folds"`
+	Accuracy float64                 `json:"accuracy"`
+	MacroF1  float64                 `json:"macro_f1"`
+	Labels   map[string]LabelMetrics `json:"labels"`
+}
+
+type TrainResult struct {
+	Algorithm string     `json:"algorithm"`
+	Labels    []string   `json:"labels"`
+	DocCount  int        `json:"doc_count"`
+	CV        *CVMetrics `json:"cv,omitempty"`
+}
+
+type classifierHolder struct {
+	sync.Mutex
+	model *ClassifierModel
+}
+
+func (h *classifierHolder) get() *ClassifierModel {
+	defer h.Unlock()
+	h.Lock()
+	return h.model
+}
+
+func (h *classifierHolder) set(m *ClassifierModel) {
+	m.index()
+	defer h.Unlock()
+	h.Lock()
+	h.model = m
+}
+
+// termCounts counts terms of the doc, field boosts are left out
+func (t *TFIDF) termCounts(doc Doc) map[string]float64 {
+	res := make(map[string]float64)
+	for _, tm := range t.terms(doc) {
+		res[tm.value]++
+	}
+	return res
+}
+
+// termWeights is the L2 normalized TF-IDF vector of the doc keyed by terms
+func (t *TFIDF) termWeights(doc Doc) map[string]float64 {
+	vec := t.docVector(doc).normalize()
+	res := make(map[string]float64, len(vec))
+	for index, v := range vec {
+		if value, ok := t.wordValue(index); ok {
+			res[value] = v
+		}
+	}
+	return res
+}
+
+func (t *TFIDF) train(docs []Doc, opts TrainOptions) *ClassifierModel {
+	m := &ClassifierModel{
+		Algorithm: opts.Algorithm,
+		DocCount:  len(docs),
+		TrainedAt: time.Now().UTC(),
+	}
+	docCounts := make(map[string]int)
+	for i := range docs {
+		for _, label := range docs[i].Labels {
+			docCounts[label]++
+		}
+	}
+	for label := range docCounts {
+		m.Labels = append(m.Labels, label)
+	}
+	sort.Strings(m.Labels)
+
+	switch opts.Algorithm {
+	case Rocchio:
+		m.Centroids = make(map[string]map[string]float64, len(m.Labels))
+		for i := range docs {
+			weights := t.termWeights(docs[i])
+			for _, label := range docs[i].Labels {
+				c := m.Centroids[label]
+				if c == nil {
+					c = make(map[string]float64)
+					m.Centroids[label] = c
+				}
+				for term, w := range weights {
+					c[term] += w
+				}
+			}
+		}
+		for _, c := range m.Centroids {
+			normalizeWeights(c)
+		}
+	default:
+		counts := make(map[string]map[string]float64, len(m.Labels))
+		totals := make(map[string]float64, len(m.Labels))
+		vocabulary := make(set)
+		for i := range docs {
+			tc := t.termCounts(docs[i])
+			for _, label := range docs[i].Labels {
+				c := counts[label]
+				if c == nil {
+					c = make(map[string]float64)
+					counts[label] = c
+				}
+				for term, n := range tc {
+					c[term] += n
+					totals[label] += n
+					vocabulary.set(term)
+				}
+			}
+		}
+		labelTotal := 0
+		for _, n := range docCounts {
+			labelTotal += n
+		}
+		m.LogPriors = make(map[string]float64, len(m.Labels))
+		m.LogProbs = make(map[string]map[string]float64, len(m.Labels))
+		m.LogUnknown = make(map[string]float64, len(m.Labels))
+		for term := range vocabulary {
+			m.Vocabulary = append(m.Vocabulary, term)
+		}
+		sort.Strings(m.Vocabulary)
+		m.vocabulary = vocabulary
+		v := float64(len(vocabulary))
+		for _, label := range m.Labels {
+			m.LogPriors[label] = math.Log(float64(docCounts[label]) / float64(labelTotal))
+			denominator := totals[label] + opts.Alpha*v
+			m.LogUnknown[label] = math.Log(opts.Alpha / denominator)
+			m.LogProbs[label] = make(map[string]float64, len(counts[label]))
+			for term, n := range counts[label] {
+				m.LogProbs[label][term] = math.Log((n + opts.Alpha) / denominator)
+			}
+		}
+	}
+	return m
+}
+
+func normalizeWeights(w map[string]float64) {
+	norm := 0.0
+	for _, v := range w {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return
+	}
+	for k := range w {
+		w[k] /= norm
+	}
+}
+
+// predict returns probabilities of labels, naive bayes normalizes posteriors,
+// rocchio normalizes cosine similarities to centroids
+func (t *TFIDF) predict(m *ClassifierModel, doc Doc) Classification {
+	scores := make([]float64, len(m.Labels))
+	switch m.Algorithm {
+	case Rocchio:
+		weights := t.termWeights(doc)
+		sum := 0.0
+		for i, label := range m.Labels {
+			for term, w := range weights {
+				scores[i] += w * m.Centroids[label][term]
+			}
+			if scores[i] < 0 {
+				scores[i] = 0
+			}
+			sum += scores[i]
+		}
+		for i := range scores {
+			if sum == 0 {
+				scores[i] = 1 / float64(len(scores))
+				continue
+			}
+			scores[i] /= sum
+		}
+	default:
+		tc := t.termCounts(doc)
+		max := math.Inf(-1)
+		for i, label := range m.Labels {
+			scores[i] = m.LogPriors[label]
+			for term, n := range tc {
+				if !m.vocabulary.exist(term) {
+					continue
+				}
+				lp, ok := m.LogProbs[label][term]
+				if !ok {
+					lp = m.LogUnknown[label]
+				}
+				scores[i] += n * lp
+			}
+			if scores[i] > max {
+				max = scores[i]
+			}
+		}
+		// softmax shifted by the max to avoid underflow
+		sum := 0.0
+		for i := range scores {
+			scores[i] = math.Exp(scores[i] - max)
+			sum += scores[i]
+		}
+		for i := range scores {
+			scores[i] /= sum
+		}
+	}
+
+	res := Classification{
+		ID:            doc.ID,
+		Probabilities: make([]LabelProbability, len(m.Labels)),
+	}
+	for i, label := range m.Labels {
+		res.Probabilities[i] = LabelProbability{Label: label, Probability: scores[i]}
+	}
+	sort.SliceStable(res.Probabilities, func(i, j int) bool {
+		return res.Probabilities[i].Probability > res.Probabilities[j].Probability
+	})
+	if len(res.Probabilities) > 0 {
+		res.Label = res.Probabilities[0].Label
+	}
+	return res
+}
+
+func (t *TFIDF) crossValidate(ctx context.Context, docs []Doc, opts TrainOptions) (*CVMetrics, error) {
+	order := rand.New(rand.NewSource(opts.Seed)).Perm(len(docs))
+	tp := make(map[string]int)
+	fp := make(map[string]int)
+	support := make(map[string]int)
+	correct := 0
+	for fold := 0; fold < opts.Folds; fold++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		train := make([]Doc, 0, len(docs))
+		test := make([]Doc, 0, len(docs)/opts.Folds+1)
+		for i, j := range order {
+			if i%opts.Folds == fold {
+				test = append(test, docs[j])
+			} else {
+				train = append(train, docs[j])
+			}
+		}
+		m := t.train(train, opts)
+		for i := range test {
+			predicted := t.predict(m, test[i]).Label
+			hit := false
+			for _, label := range test[i].Labels {
+				support[label]++
+				if label == predicted {
+					hit = true
+				}
+			}
+			if hit {
+				correct++
+				tp[predicted]++
+			} else {
+				fp[predicted]++
+			}
+		}
+	}
+
+	res := &CVMetrics{
+		Folds:    opts.Folds,
+		Accuracy: float64(correct) / float64(len(docs)),
+		Labels:   make(map[string]LabelMetrics, len(support)),
+	}
+	for label, n := range support {
+		lm := LabelMetrics{Support: n}
+		if tp[label]+fp[label] > 0 {
+			lm.Precision = float64(tp[label]) / float64(tp[label]+fp[label])
+		}
+		lm.Recall = float64(tp[label]) / float64(n)
+		if lm.Precision+lm.Recall > 0 {
+			lm.F1 = 2 * lm.Precision * lm.Recall / (lm.Precision + lm.Recall)
+		}
+		res.Labels[label] = lm
+		res.MacroF1 += lm.F1
+	}
+	res.MacroF1 /= float64(len(support))
+	return res, nil
+}
+
+// Train fits the classifier on stored docs carrying labels after cross validating it
+func (t *TFIDF) Train(ctx context.Context, opts TrainOptions) (*TrainResult, error) {
+	switch opts.Algorithm {
+	case "":
+		opts.Algorithm = NaiveBayes
+	case NaiveBayes, Rocchio:
+	default:
+		return nil, invalidf("algorithm", "unsupported algorithm %q", opts.Algorithm)
+	}
+	if opts.Folds == 0 {
+		opts.Folds = defaultTrainFolds
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = defaultNBAlpha
+	}
+	if opts.Alpha < 0 {
+		return nil, invalidf("alpha", "alpha should be positive")
+	}
+
+	docs := make([]Doc, 0)
+	labels := make(set)
+	for _, doc := range t.storedDocs() {
+		if len(doc.Labels) == 0 {
+			continue
+		}
+		docs = append(docs, doc)
+		for _, label := range doc.Labels {
+			labels.set(label)
+		}
+	}
+	if len(labels) < 2 {
+		return nil, invalidf("", "training needs docs of at least 2 labels, got %d", len(labels))
+	}
+	if opts.Folds < 1 || opts.Folds > len(docs) {
+		return nil, invalidf("folds", "folds should be 1 to %d labeled docs", len(docs))
+	}
+
+	res := &TrainResult{
+		Algorithm: opts.Algorithm,
+		DocCount:  len(docs),
+	}
+	if opts.Folds > 1 {
+		cv, err := t.crossValidate(ctx, docs, opts)
+		if err != nil {
+			return nil, err
+		}
+		res.CV = cv
+	}
+	m := t.train(docs, opts)
+	res.Labels = m.Labels
+	t.classifier.set(m)
+	t.pd.Lock()
+	t.pd.updated = true
+	t.pd.Unlock()
+	return res, nil
+}
+
+// Classify predicts labels of the docs without upserting them
+func (t *TFIDF) Classify(ctx context.Context, docs []Doc) ([]Classification, error) {
+	m := t.classifier.get()
+	if m == nil {
+		return nil, ErrClassifierNotTrained
+	}
+	res := make([]Classification, 0, len(docs))
+	for _, doc := range t.analyzeDocs(docs) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res = append(res, t.predict(m, doc))
+	}
+	return res, nil
+}
+
+func (s *Server) Train(ctx *gin.Context) {
+	req := TrainOptions{}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	res, err := s.engine().Train(ctx.Request.Context(), req)
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (s *Server) Classify(ctx *gin.Context) {
+	req := make([]Doc, 0)
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if len(req) == 0 {
+		abortInvalid(ctx, invalidf("", "no docs to classify"))
+		return
+	}
+	for i := range req {
+		err := req[i].validate(fmt.Sprintf("[%d].", i), false)
+		if err != nil {
+			abortInvalid(ctx, err)
+			return
+		}
+	}
+	res, err := s.engine().Classify(ctx.Request.Context(), req)
+	if err == ErrClassifierNotTrained {
+		abortWithError(ctx, http.StatusConflict, ErrCodeNotReady, err)
+		return
+	} else if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
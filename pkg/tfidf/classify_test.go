@@ -0,0 +1,93 @@
+package tfidf
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func classifyTestTFIDF(t *testing.T) *TFIDF {
+	t.Helper()
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "1", Words: []string{"ball", "goal", "team"}, Labels: []string{"sports"}},
+		{ID: "2", Words: []string{"goal", "match", "team"}, Labels: []string{"sports"}},
+		{ID: "3", Words: []string{"pizza", "pasta", "cheese"}, Labels: []string{"food"}},
+		{ID: "4", Words: []string{"cheese", "bread", "pasta", "olive"}, Labels: []string{"food"}},
+		{ID: "5", Words: []string{"unlabeled"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tfidf
+}
+
+func TestClassify(t *testing.T) {
+	ctx := context.Background()
+	tfidf := classifyTestTFIDF(t)
+	doc := Doc{ID: "q", Words: []string{"goal", "team", "unicorn"}}
+
+	res, err := tfidf.Train(ctx, TrainOptions{Folds: 1})
+	if err != nil || res.DocCount != 4 || res.CV != nil {
+		t.Fatalf("expected 4 docs trained without cv, got %+v, %v", res, err)
+	}
+	c, err := tfidf.Classify(ctx, []Doc{doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9 terms, sports has 6 of them and food 7, unicorn is left out
+	sports := (3.0 / 15) * (3.0 / 15)
+	food := (1.0 / 16) * (1.0 / 16)
+	expected := sports / (sports + food)
+	if c[0].Label != "sports" || math.Abs(c[0].Probabilities[0].Probability-expected) > 1e-9 {
+		t.Fatalf("expected sports with probability %v, got %+v", expected, c[0])
+	}
+
+	_, err = tfidf.Train(ctx, TrainOptions{Algorithm: Rocchio, Folds: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = tfidf.Classify(ctx, []Doc{doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no term of the doc is in the food centroid
+	if c[0].Label != "sports" || c[0].Probabilities[0].Probability != 1 || c[0].Probabilities[1].Probability != 0 {
+		t.Fatalf("expected sports with probability 1, got %+v", c[0])
+	}
+}
+
+func TestTrainCrossValidation(t *testing.T) {
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "1", Words: []string{"goal", "team", "ball"}, Labels: []string{"sports"}},
+		{ID: "2", Words: []string{"goal", "team", "match"}, Labels: []string{"sports"}},
+		{ID: "3", Words: []string{"goal", "team", "coach"}, Labels: []string{"sports"}},
+		{ID: "4", Words: []string{"cheese", "pasta", "pizza"}, Labels: []string{"food"}},
+		{ID: "5", Words: []string{"cheese", "pasta", "bread"}, Labels: []string{"food"}},
+		{ID: "6", Words: []string{"cheese", "pasta", "olive"}, Labels: []string{"food"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algorithm := range []string{NaiveBayes, Rocchio} {
+		res, err := tfidf.Train(context.Background(), TrainOptions{Algorithm: algorithm, Folds: 3, Seed: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cv := res.CV
+		if cv == nil || cv.Folds != 3 || cv.Accuracy != 1 || cv.MacroF1 != 1 || len(cv.Labels) != 2 {
+			t.Fatalf("%s: expected every fold classified correctly, got %+v", algorithm, cv)
+		}
+		for label, lm := range cv.Labels {
+			if lm != (LabelMetrics{Precision: 1, Recall: 1, F1: 1, Support: 3}) {
+				t.Fatalf("%s: unexpected metrics of %s %+v", algorithm, label, lm)
+			}
+		}
+	}
+
+	_, err = tfidf.Train(context.Background(), TrainOptions{Folds: 7})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("expected more folds than docs to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,457 @@
+// Package client is a typed client of tfidf-server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sudalight/tools/pkg/tfidf"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+	defaultBatchSize  = 500
+)
+
+// APIError is returned when the server responds with a non 2xx status
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Field      string
+}
+
+func (e *APIError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("tfidf-server responded %d %s: %s: %s", e.StatusCode, e.Code, e.Field, e.Message)
+	}
+	return fmt.Sprintf("tfidf-server responded %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// retryable reports whether the request may succeed if sent again, route
+// timeouts would only repeat the work that timed out
+func (e *APIError) retryable() bool {
+	if e.Code == tfidf.ErrCodeTimeout {
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// idempotentPosts are POST routes sending the same request twice leaves the
+// server as sending it once, upserts replace docs by id. Others like /train,
+// /lsa/fit and /admin/restore are not retried since they may still be running.
+var idempotentPosts = map[string]bool{
+	"/upsert_docs":           true,
+	"/get_doc_vector":        true,
+	"/search":                true,
+	"/cluster":               true,
+	"/lsa/embed":             true,
+	"/classify":              true,
+	"/admin/synonyms/reload": true,
+}
+
+func idempotent(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return idempotentPosts[path]
+	}
+	return false
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.httpClient = c
+	}
+}
+
+// WithRetries sets how many times a failed idempotent request is resent, 0 disables retries
+func WithRetries(n int) Option {
+	return func(cl *Client) {
+		cl.maxRetries = n
+	}
+}
+
+// WithBackoff sets the exponential backoff between retries
+func WithBackoff(min, max time.Duration) Option {
+	return func(cl *Client) {
+		cl.minBackoff = min
+		cl.maxBackoff = max
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// UpsertDocs upserts docs in order, a batch cancelled or timed out on the
+// server is partly committed, so a retry upserts the committed prefix again
+func (c *Client) UpsertDocs(ctx context.Context, docs []tfidf.Doc) error {
+	return c.do(ctx, http.MethodPost, "/upsert_docs", nil, docs, nil)
+}
+
+// UpsertDocsInBatches splits docs into batches of batchSize and upserts them in order,
+// it stops at the first failed batch and returns the number of upserted docs.
+func (c *Client) UpsertDocsInBatches(ctx context.Context, docs []tfidf.Doc, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	for start := 0; start < len(docs); start += batchSize {
+		end := start + batchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		err := c.UpsertDocs(ctx, docs[start:end])
+		if err != nil {
+			return start, err
+		}
+	}
+	return len(docs), nil
+}
+
+func (c *Client) UpsertDocsWithPolicy(ctx context.Context, docs []tfidf.Doc,
+	policy tfidf.DuplicatePolicy, threshold float64) (*tfidf.UpsertResult, error) {
+	query := url.Values{}
+	query.Set("on_duplicate", string(policy))
+	if threshold > 0 {
+		query.Set("threshold", strconv.FormatFloat(threshold, 'f', -1, 64))
+	}
+	res := &tfidf.UpsertResult{}
+	if policy == tfidf.DuplicateAllow {
+		res.Upserted = len(docs)
+		return res, c.UpsertDocs(ctx, docs)
+	}
+	return res, c.do(ctx, http.MethodPost, "/upsert_docs", query, docs, res)
+}
+
+// GetDocVector upserts the doc as the server does and returns its TF-IDF vector
+func (c *Client) GetDocVector(ctx context.Context, doc tfidf.Doc) ([]*tfidf.WordTFIDF, error) {
+	res := []*tfidf.WordTFIDF{}
+	return res, c.do(ctx, http.MethodPost, "/get_doc_vector", nil, doc, &res)
+}
+
+// GetDocVectors requests vectors one doc after another, results are in the order of docs
+func (c *Client) GetDocVectors(ctx context.Context, docs []tfidf.Doc) ([][]*tfidf.WordTFIDF, error) {
+	res := make([][]*tfidf.WordTFIDF, 0, len(docs))
+	for i := range docs {
+		vec, err := c.GetDocVector(ctx, docs[i])
+		if err != nil {
+			return res, err
+		}
+		res = append(res, vec)
+	}
+	return res, nil
+}
+
+// ExplainDocVector works like GetDocVector and explains every value
+func (c *Client) ExplainDocVector(ctx context.Context, doc tfidf.Doc) ([]*tfidf.WordTFIDF, error) {
+	query := url.Values{}
+	query.Set("explain", "true")
+	res := []*tfidf.WordTFIDF{}
+	return res, c.do(ctx, http.MethodPost, "/get_doc_vector", query, doc, &res)
+}
+
+func (c *Client) Statistics(ctx context.Context) (*tfidf.Statistics, error) {
+	res := &tfidf.Statistics{}
+	return res, c.do(ctx, http.MethodGet, "/statistics", nil, nil, res)
+}
+
+// Analytics reports top and bottom words by df, doc lengths and vocabulary growth
+func (c *Client) Analytics(ctx context.Context, top, buckets int) (*tfidf.Analytics, error) {
+	query := url.Values{}
+	query.Set("top", strconv.Itoa(top))
+	query.Set("buckets", strconv.Itoa(buckets))
+	res := &tfidf.Analytics{}
+	return res, c.do(ctx, http.MethodGet, "/analytics", query, nil, res)
+}
+
+func (c *Client) GetDoc(ctx context.Context, id string) (*tfidf.Doc, error) {
+	res := &tfidf.Doc{}
+	return res, c.do(ctx, http.MethodGet, "/docs/"+url.PathEscape(id), nil, nil, res)
+}
+
+// StoredDocVector returns the vector of a stored doc without upserting it,
+// explanations carry the words of the values
+func (c *Client) StoredDocVector(ctx context.Context, id string, explain bool) ([]*tfidf.WordTFIDF, error) {
+	query := url.Values{}
+	if explain {
+		query.Set("explain", "true")
+	}
+	res := []*tfidf.WordTFIDF{}
+	return res, c.do(ctx, http.MethodGet, "/docs/"+url.PathEscape(id)+"/vector", query, nil, &res)
+}
+
+// RelatedDocs lists precomputed related docs, limit 0 returns all of them
+func (c *Client) RelatedDocs(ctx context.Context, id string, limit int) (*tfidf.RelatedDocs, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	res := &tfidf.RelatedDocs{}
+	return res, c.do(ctx, http.MethodGet, "/docs/"+url.PathEscape(id)+"/related", query, nil, res)
+}
+
+func (c *Client) DeleteDoc(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/docs/"+url.PathEscape(id), nil, nil, nil)
+}
+
+func (c *Client) ListDocs(ctx context.Context, offset, limit int, sortBy string) (*tfidf.DocPage, error) {
+	res := &tfidf.DocPage{}
+	return res, c.do(ctx, http.MethodGet, "/docs", pageQuery(offset, limit, sortBy), nil, res)
+}
+
+func (c *Client) LookupWord(ctx context.Context, word string) (*tfidf.WordInfo, error) {
+	res := &tfidf.WordInfo{}
+	return res, c.do(ctx, http.MethodGet, "/words/"+url.PathEscape(word), nil, nil, res)
+}
+
+func (c *Client) WordByIndex(ctx context.Context, i int) (*tfidf.WordInfo, error) {
+	res := &tfidf.WordInfo{}
+	return res, c.do(ctx, http.MethodGet, "/words/by-index/"+strconv.Itoa(i), nil, nil, res)
+}
+
+func (c *Client) ListWords(ctx context.Context, offset, limit int, sortBy string) (*tfidf.WordPage, error) {
+	res := &tfidf.WordPage{}
+	return res, c.do(ctx, http.MethodGet, "/words", pageQuery(offset, limit, sortBy), nil, res)
+}
+
+func (c *Client) Cluster(ctx context.Context, opts tfidf.ClusterOptions) (*tfidf.ClusterResult, error) {
+	res := &tfidf.ClusterResult{}
+	return res, c.do(ctx, http.MethodPost, "/cluster", nil, opts, res)
+}
+
+func (c *Client) Duplicates(ctx context.Context, threshold float64) ([]tfidf.DuplicateCluster, error) {
+	query := url.Values{}
+	if threshold > 0 {
+		query.Set("threshold", strconv.FormatFloat(threshold, 'f', -1, 64))
+	}
+	res := []tfidf.DuplicateCluster{}
+	return res, c.do(ctx, http.MethodGet, "/duplicates", query, nil, &res)
+}
+
+func (c *Client) Query(ctx context.Context, q string, offset, limit int, explain bool) (*tfidf.QueryResult, error) {
+	query := pageQuery(offset, limit, "")
+	query.Set("q", q)
+	if explain {
+		query.Set("explain", "true")
+	}
+	res := &tfidf.QueryResult{}
+	return res, c.do(ctx, http.MethodGet, "/query", query, nil, res)
+}
+
+func (c *Client) Suggest(ctx context.Context, word string, maxDistance, limit int) ([]tfidf.Suggestion, error) {
+	query := url.Values{}
+	query.Set("word", word)
+	query.Set("max_distance", strconv.Itoa(maxDistance))
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	res := []tfidf.Suggestion{}
+	return res, c.do(ctx, http.MethodGet, "/suggest", query, nil, &res)
+}
+
+func (c *Client) Search(ctx context.Context, req tfidf.SearchRequest) ([]tfidf.SearchHit, error) {
+	res := []tfidf.SearchHit{}
+	return res, c.do(ctx, http.MethodPost, "/search", nil, req, &res)
+}
+
+// RebuildANN rehashes the LSH index used by approximate search
+func (c *Client) RebuildANN(ctx context.Context) (*tfidf.ANNStats, error) {
+	res := &tfidf.ANNStats{}
+	return res, c.do(ctx, http.MethodPost, "/ann/rebuild", nil, nil, res)
+}
+
+func (c *Client) FitLSA(ctx context.Context, opts tfidf.LSAOptions) (*tfidf.LSAFitResult, error) {
+	res := &tfidf.LSAFitResult{}
+	return res, c.do(ctx, http.MethodPost, "/lsa/fit", nil, opts, res)
+}
+
+func (c *Client) Embed(ctx context.Context, req tfidf.EmbedRequest) ([]tfidf.LSAEmbedding, error) {
+	res := []tfidf.LSAEmbedding{}
+	return res, c.do(ctx, http.MethodPost, "/lsa/embed", nil, req, &res)
+}
+
+// Train fits the classifier on stored docs carrying labels
+func (c *Client) Train(ctx context.Context, opts tfidf.TrainOptions) (*tfidf.TrainResult, error) {
+	res := &tfidf.TrainResult{}
+	return res, c.do(ctx, http.MethodPost, "/train", nil, opts, res)
+}
+
+// Classify predicts label probabilities of docs without upserting them
+func (c *Client) Classify(ctx context.Context, docs []tfidf.Doc) ([]tfidf.Classification, error) {
+	res := make([]tfidf.Classification, 0)
+	return res, c.do(ctx, http.MethodPost, "/classify", nil, docs, &res)
+}
+
+// Changes lists changes after the sequence number, resync when the page is reset
+func (c *Client) Changes(ctx context.Context, since uint64) (*tfidf.ChangePage, error) {
+	query := url.Values{}
+	query.Set("since", strconv.FormatUint(since, 10))
+	res := &tfidf.ChangePage{}
+	return res, c.do(ctx, http.MethodGet, "/changes", query, nil, res)
+}
+
+// ReloadSynonyms makes the server read its synonym dictionary file again
+func (c *Client) ReloadSynonyms(ctx context.Context) (*tfidf.SynonymStats, error) {
+	res := &tfidf.SynonymStats{}
+	return res, c.do(ctx, http.MethodPost, "/admin/synonyms/reload", nil, nil, res)
+}
+
+// Backup downloads a point-in-time backup of the corpus
+func (c *Client) Backup(ctx context.Context) (*tfidf.Backup, error) {
+	res := &tfidf.Backup{}
+	return res, c.do(ctx, http.MethodGet, "/admin/backup", nil, nil, res)
+}
+
+// Restore replaces the corpus served by the backup
+func (c *Client) Restore(ctx context.Context, b *tfidf.Backup) (*tfidf.Statistics, error) {
+	res := &tfidf.Statistics{}
+	return res, c.do(ctx, http.MethodPost, "/admin/restore", nil, b, res)
+}
+
+func pageQuery(offset, limit int, sortBy string) url.Values {
+	query := url.Values{}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if sortBy != "" {
+		query.Set("sort", sortBy)
+	}
+	return query
+}
+
+// do sends the request and decodes the response into out,
+// network errors, 429 and 5xx responses are retried with exponential backoff.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	retries := c.maxRetries
+	if !idempotent(method, path) {
+		retries = 0
+	}
+	backoff := c.minBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, u, body, out)
+		if err == nil {
+			return nil
+		}
+		if apiErr, ok := err.(*APIError); ok && !apiErr.retryable() {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= retries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, u string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp.StatusCode, data)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// newAPIError decodes tfidf.ErrorBody, bodies of other forms become the message
+func newAPIError(status int, data []byte) *APIError {
+	e := &APIError{StatusCode: status}
+	body := tfidf.ErrorBody{}
+	if json.Unmarshal(data, &body) == nil && body.Code != "" {
+		e.Code = body.Code
+		e.Message = body.Message
+		e.Field = body.Field
+		return e
+	}
+	msg := ""
+	if json.Unmarshal(data, &msg) == nil {
+		e.Message = msg
+		return e
+	}
+	e.Message = strings.TrimSpace(string(data))
+	return e
+}
@@ -0,0 +1,384 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sudalight/tools/pkg/tfidf"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestServer(t *testing.T, middlewares ...gin.HandlerFunc) *httptest.Server {
+	t.Helper()
+	return newLimitedTestServer(t, tfidf.Limits{}, middlewares...)
+}
+
+func newLimitedTestServer(t *testing.T, limits tfidf.Limits, middlewares ...gin.HandlerFunc) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	server, err := tfidf.NewServer(filepath.Join(dir, "tfidf.json"), filepath.Join(dir, "file-descriptor.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.SetLimits(limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.Use(middlewares...)
+	server.Register(router)
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestClient(ts *httptest.Server) *Client {
+	return New(ts.URL, WithBackoff(time.Millisecond, 10*time.Millisecond))
+}
+
+func TestUpsertAndStatistics(t *testing.T) {
+	c := newTestClient(newTestServer(t))
+	ctx := context.Background()
+
+	err := c.UpsertDocs(ctx, []tfidf.Doc{
+		{ID: "1", Words: []string{"a", "b"}},
+		{ID: "2", Words: []string{"b", "c"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.Statistics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DocCount != 2 || stats.WordCount != 3 {
+		t.Errorf("unexpected statistics %+v", stats)
+	}
+
+	doc, err := c.GetDoc(ctx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Words) != 2 || doc.Words[0] != "b" {
+		t.Errorf("unexpected doc %+v", doc)
+	}
+}
+
+func TestGetDocVector(t *testing.T) {
+	c := newTestClient(newTestServer(t))
+	ctx := context.Background()
+
+	err := c.UpsertDocs(ctx, []tfidf.Doc{
+		{ID: "1", Words: []string{"a", "b"}},
+		{ID: "2", Words: []string{"a", "c"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vec, err := c.GetDocVector(ctx, tfidf.Doc{ID: "3", Words: []string{"a", "d"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vec) != 2 {
+		t.Fatalf("expected 2 values, got %d", len(vec))
+	}
+	if vec[0].Index != 0 || vec[1].Index != 3 {
+		t.Errorf("unexpected indexes %d, %d", vec[0].Index, vec[1].Index)
+	}
+	if vec[1].Value <= vec[0].Value {
+		t.Errorf("rare word should weigh more, got %v <= %v", vec[1].Value, vec[0].Value)
+	}
+
+	info, err := c.WordByIndex(ctx, vec[1].Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Word != "d" || info.DF != 1 {
+		t.Errorf("unexpected word %+v", info)
+	}
+
+	stored, err := c.StoredDocVector(ctx, "3", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[1].Value != vec[1].Value || stored[1].Explanation.Word != "d" {
+		t.Errorf("expected the stored vector to match, got %+v", stored)
+	}
+	stats, err := c.Statistics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DocCount != 3 {
+		t.Errorf("expected the stored vector not to upsert, got %+v", stats)
+	}
+	_, err = c.StoredDocVector(ctx, "missing", false)
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestUpsertDocsInBatches(t *testing.T) {
+	var requests int32
+	c := newTestClient(newTestServer(t, func(ctx *gin.Context) {
+		if ctx.Request.URL.Path == "/upsert_docs" {
+			atomic.AddInt32(&requests, 1)
+		}
+	}))
+	ctx := context.Background()
+
+	docs := make([]tfidf.Doc, 0, 7)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		docs = append(docs, tfidf.Doc{ID: id, Words: []string{"w" + id}})
+	}
+	n, err := c.UpsertDocsInBatches(ctx, docs, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(docs) {
+		t.Errorf("expected %d upserted docs, got %d", len(docs), n)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	page, err := c.ListDocs(ctx, 5, 10, tfidf.SortByID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 7 || len(page.Docs) != 2 || page.Docs[0].ID != "6" {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var failures int32 = 2
+	c := newTestClient(newTestServer(t, func(ctx *gin.Context) {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, "unavailable")
+		}
+	}))
+
+	_, err := c.Statistics(context.Background())
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+
+	atomic.StoreInt32(&failures, 10)
+	_, err = New(c.baseURL, WithRetries(1), WithBackoff(time.Millisecond, time.Millisecond)).
+		Statistics(context.Background())
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after retries exhausted, got %v", err)
+	}
+}
+
+func TestNoRetryOfNonIdempotent(t *testing.T) {
+	var requests int32
+	c := newTestClient(newTestServer(t, func(ctx *gin.Context) {
+		atomic.AddInt32(&requests, 1)
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, "unavailable")
+	}))
+	ctx := context.Background()
+
+	_, err := c.Train(ctx, tfidf.TrainOptions{})
+	if err == nil || requests != 1 {
+		t.Fatalf("expected training sent once, got %d requests, %v", requests, err)
+	}
+	atomic.StoreInt32(&requests, 0)
+	_, err = c.Search(ctx, tfidf.SearchRequest{Doc: tfidf.Doc{Words: []string{"a"}}})
+	if err == nil || requests != int32(defaultMaxRetries+1) {
+		t.Fatalf("expected search retried, got %d requests, %v", requests, err)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	var requests int32
+	c := newTestClient(newTestServer(t, func(ctx *gin.Context) {
+		atomic.AddInt32(&requests, 1)
+	}))
+
+	_, err := c.GetDoc(context.Background(), "missing")
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %v", err)
+	}
+	if apiErr.Code != tfidf.ErrCodeNotFound {
+		t.Errorf("expected code %s, got %s", tfidf.ErrCodeNotFound, apiErr.Code)
+	}
+	if requests != 1 {
+		t.Errorf("client errors should not be retried, got %d requests", requests)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var requests int32
+	c := newTestClient(newLimitedTestServer(t, tfidf.Limits{
+		Timeout:           time.Nanosecond,
+		RouteTimeouts:     map[string]time.Duration{"POST /search": time.Minute},
+		MaxBodySize:       100,
+		RouteMaxBodySizes: map[string]int64{"POST /search": 1 << 20},
+	}, func(ctx *gin.Context) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	ctx := context.Background()
+
+	_, err := c.GetDocVector(ctx, tfidf.Doc{ID: "1", Words: []string{"a"}})
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Code != tfidf.ErrCodeTimeout {
+		t.Fatalf("expected 503 timeout, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("timeouts should not be retried, got %d requests", requests)
+	}
+
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = "a"
+	}
+	_, err = c.GetDocVector(ctx, tfidf.Doc{ID: "1", Words: words})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %v", err)
+	}
+
+	_, err = c.Search(ctx, tfidf.SearchRequest{Doc: tfidf.Doc{Words: words}})
+	if err != nil {
+		t.Errorf("route limits should override the defaults, got %v", err)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	c := newTestClient(newTestServer(t))
+
+	err := c.UpsertDocs(context.Background(), []tfidf.Doc{
+		{ID: "1", Words: []string{"a"}},
+		{ID: "1", Words: []string{"b"}},
+	})
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %v", err)
+	}
+	if apiErr.Code != tfidf.ErrCodeInvalidParameter || apiErr.Field != "[1].id" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+
+	// text of punctuation only analyzes to no words, the whole batch is rejected
+	err = c.UpsertDocs(context.Background(), []tfidf.Doc{
+		{ID: "1", Words: []string{"a"}},
+		{ID: "2", Text: "!?... --"},
+	})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Field != "[1].words" {
+		t.Fatalf("expected 400 for [1].words, got %v", err)
+	}
+	stats, err := c.Statistics(context.Background())
+	if err != nil || stats.DocCount != 0 {
+		t.Errorf("expected no doc upserted, got %+v, %v", stats, err)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	c := New(newTestServer(t, func(ctx *gin.Context) {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, "boom")
+	}).URL, WithBackoff(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Statistics(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	c := newTestClient(newTestServer(t))
+	ctx := context.Background()
+
+	err := c.UpsertDocs(ctx, []tfidf.Doc{
+		{ID: "1", Words: []string{"a", "b"}},
+		{ID: "2", Words: []string{"b", "c"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := c.Backup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.UpsertDocs(ctx, []tfidf.Doc{{ID: "3", Words: []string{"d"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.Restore(ctx, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DocCount != 2 || stats.WordCount != 3 {
+		t.Errorf("unexpected statistics after restore %+v", stats)
+	}
+	_, err = c.GetDoc(ctx, "3")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("doc 3 should be gone after restore, got %v", err)
+	}
+
+	backup.Snapshot.Docs = append(backup.Snapshot.Docs, backup.Snapshot.Docs[0])
+	backup.Snapshot.DocCount++
+	_, err = c.Restore(ctx, backup)
+	if !errors.As(err, &apiErr) || apiErr.Code != tfidf.ErrCodeInvalidParameter || apiErr.Field != "snapshot" {
+		t.Errorf("want 400 for a duplicate doc, got %v", err)
+	}
+	if stats, err := c.Statistics(ctx); err != nil || stats.DocCount != 2 {
+		t.Errorf("rejected backup should not be restored, got %+v, %v", stats, err)
+	}
+
+	backup.Version = 0
+	_, err = c.Restore(ctx, backup)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("want 400 for unsupported version, got %v", err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	c := newTestClient(newTestServer(t))
+	ctx := context.Background()
+
+	err := c.UpsertDocs(ctx, []tfidf.Doc{{ID: "1", Words: []string{"a", "b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.UpsertDocs(ctx, []tfidf.Doc{{ID: "1", Words: []string{"a", "c"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := c.Changes(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Reset || page.Seq != 2 || len(page.Changes) != 1 {
+		t.Fatalf("unexpected page %+v", page)
+	}
+	change := page.Changes[0]
+	if change.Op != tfidf.OpUpdate || change.DocID != "1" ||
+		len(change.Added) != 1 || change.Added[0] != "c" || len(change.Removed) != 1 || change.Removed[0] != "b" {
+		t.Errorf("unexpected change %+v", change)
+	}
+
+	page, err = c.Changes(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.Reset || len(page.Changes) != 2 {
+		t.Errorf("want a reset page with all changes, got %+v", page)
+	}
+}
@@ -0,0 +1,214 @@
+package tfidf
+
+import (
+	"context"
+	"math/rand"
+)
+
+const (
+	defaultClusterMaxIter  = 100
+	defaultClusterSeed     = 1
+	defaultClusterTopTerms = 10
+)
+
+type ClusterOptions struct {
+	K        int   `json:"k"`
+	MaxIter  int   `json:"max_iter,omitempty"`
+	Seed     int64 `json:"seed,omitempty"`
+	TopTerms int   `json:"top_terms,omitempty"`
+}
+
+type TermWeight struct {
+	Word   string  `json:"word"`
+	Index  int     `json:"index"`
+	Weight float64 `json:"weight"`
+}
+
+type Cluster struct {
+	ID       int          `json:"id"`
+	Size     int          `json:"size"`
+	TopTerms []TermWeight `json:"top_terms"`
+}
+
+type ClusterResult struct {
+	// doc id to cluster id
+	Assignments map[string]int `json:"assignments"`
+	Clusters    []Cluster      `json:"clusters"`
+	// sum of cosine distances between docs and their centroids
+	Inertia    float64 `json:"inertia"`
+	Iterations int     `json:"iterations"`
+	// docs without any known word can not be clustered
+	Unassigned []string `json:"unassigned,omitempty"`
+}
+
+// Cluster runs spherical k-means with k-means++ seeding over the normalized
+// TF-IDF vectors of stored docs, results are reproducible with the same seed.
+func (t *TFIDF) Cluster(ctx context.Context, opts ClusterOptions) (*ClusterResult, error) {
+	if opts.K < 1 {
+		return nil, invalidf("k", "k should be positive")
+	}
+	if opts.MaxIter <= 0 {
+		opts.MaxIter = defaultClusterMaxIter
+	}
+	if opts.Seed == 0 {
+		opts.Seed = defaultClusterSeed
+	}
+	if opts.TopTerms <= 0 {
+		opts.TopTerms = defaultClusterTopTerms
+	}
+
+	res := &ClusterResult{
+		Assignments: make(map[string]int),
+	}
+	docs := t.storedDocs()
+	ids := make([]string, 0, len(docs))
+	vectors := make([]sparseVector, 0, len(docs))
+	for i := range docs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vec := t.docVector(docs[i])
+		if vec.norm() == 0 {
+			res.Unassigned = append(res.Unassigned, docs[i].ID)
+			continue
+		}
+		ids = append(ids, docs[i].ID)
+		vectors = append(vectors, vec.normalize())
+	}
+	if opts.K > len(vectors) {
+		return nil, invalidf("k", "k %d is larger than the number of clusterable docs %d", opts.K, len(vectors))
+	}
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	centroids := kMeansPlusPlus(vectors, opts.K, rnd)
+	assignments := make([]int, len(vectors))
+	for i := range assignments {
+		assignments[i] = -1
+	}
+
+	for res.Iterations < opts.MaxIter {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res.Iterations++
+		changed := false
+		res.Inertia = 0
+		for i := range vectors {
+			best, sim := nearestCentroid(vectors[i], centroids)
+			res.Inertia += 1 - sim
+			if assignments[i] != best {
+				assignments[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		centroids = updateCentroids(vectors, assignments, centroids, rnd)
+	}
+
+	res.Clusters = make([]Cluster, len(centroids))
+	for i := range centroids {
+		res.Clusters[i].ID = i
+		for _, index := range centroids[i].top(opts.TopTerms) {
+			value, _ := t.wordValue(index)
+			res.Clusters[i].TopTerms = append(res.Clusters[i].TopTerms, TermWeight{
+				Word:   value,
+				Index:  index,
+				Weight: centroids[i][index],
+			})
+		}
+	}
+	for i := range ids {
+		res.Assignments[ids[i]] = assignments[i]
+		res.Clusters[assignments[i]].Size++
+	}
+	return res, nil
+}
+
+func nearestCentroid(vec sparseVector, centroids []sparseVector) (int, float64) {
+	best, bestSim := 0, -1.0
+	for j := range centroids {
+		sim := vec.dot(centroids[j])
+		if sim > bestSim {
+			best, bestSim = j, sim
+		}
+	}
+	return best, bestSim
+}
+
+func kMeansPlusPlus(vectors []sparseVector, k int, rnd *rand.Rand) []sparseVector {
+	centroids := make([]sparseVector, 0, k)
+	centroids = append(centroids, copyVector(vectors[rnd.Intn(len(vectors))]))
+
+	// squared cosine distance to the nearest chosen centroid
+	dists := make([]float64, len(vectors))
+	for i := range dists {
+		dists[i] = -1
+	}
+	for len(centroids) < k {
+		sum := 0.0
+		last := centroids[len(centroids)-1]
+		for i := range vectors {
+			d := 1 - vectors[i].dot(last)
+			if d < 0 {
+				d = 0
+			}
+			if dists[i] < 0 || d*d < dists[i] {
+				dists[i] = d * d
+			}
+			sum += dists[i]
+		}
+		if sum == 0 {
+			// remaining docs are identical to chosen centroids
+			centroids = append(centroids, copyVector(vectors[rnd.Intn(len(vectors))]))
+			continue
+		}
+		target := rnd.Float64() * sum
+		next := len(vectors) - 1
+		for i := range dists {
+			target -= dists[i]
+			if target <= 0 {
+				next = i
+				break
+			}
+		}
+		centroids = append(centroids, copyVector(vectors[next]))
+	}
+	return centroids
+}
+
+// updateCentroids takes normalized mean of members as new centroid,
+// empty clusters are reseeded by the doc farthest from its centroid.
+func updateCentroids(vectors []sparseVector, assignments []int, old []sparseVector, rnd *rand.Rand) []sparseVector {
+	centroids := make([]sparseVector, len(old))
+	for i := range centroids {
+		centroids[i] = make(sparseVector)
+	}
+	for i := range vectors {
+		centroids[assignments[i]].add(vectors[i])
+	}
+	for i := range centroids {
+		if len(centroids[i]) > 0 {
+			centroids[i].normalize()
+			continue
+		}
+		farthest, minSim := rnd.Intn(len(vectors)), 2.0
+		for j := range vectors {
+			sim := vectors[j].dot(old[assignments[j]])
+			if sim < minSim {
+				farthest, minSim = j, sim
+			}
+		}
+		centroids[i] = copyVector(vectors[farthest])
+	}
+	return centroids
+}
+
+func copyVector(v sparseVector) sparseVector {
+	res := make(sparseVector, len(v))
+	for i, x := range v {
+		res[i] = x
+	}
+	return res
+}
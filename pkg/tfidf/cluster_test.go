@@ -0,0 +1,87 @@
+package tfidf
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	tfidf := NewTFIDF()
+	rnd := rand.New(rand.NewSource(1))
+	topics := []string{"sports", "food", "music"}
+	docs := make([]Doc, 0)
+	for _, topic := range topics {
+		for i := 0; i < 10; i++ {
+			words := make([]string, 8)
+			for j := range words {
+				words[j] = fmt.Sprintf("%s%d", topic, rnd.Intn(12))
+			}
+			docs = append(docs, Doc{ID: fmt.Sprintf("%s-%d", topic, i), Words: words})
+		}
+	}
+	if _, err := tfidf.UpsertDocs(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := tfidf.Cluster(ctx, ClusterOptions{K: 3, TopTerms: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every cluster holds the docs of one topic and is described by its words
+	clusterOf := make(map[string]int)
+	for id, c := range res.Assignments {
+		topic := strings.Split(id, "-")[0]
+		if prev, ok := clusterOf[topic]; ok && prev != c {
+			t.Fatalf("topic %s split into clusters %d and %d", topic, prev, c)
+		}
+		clusterOf[topic] = c
+	}
+	if len(clusterOf) != 3 || len(res.Unassigned) != 0 {
+		t.Fatalf("expected 3 topics in 3 clusters, got %v", res.Assignments)
+	}
+	for topic, c := range clusterOf {
+		cluster := res.Clusters[c]
+		if cluster.Size != 10 || len(cluster.TopTerms) != 3 {
+			t.Fatalf("unexpected cluster of %s %+v", topic, cluster)
+		}
+		for _, tw := range cluster.TopTerms {
+			if !strings.HasPrefix(tw.Word, topic) || tw.Weight <= 0 {
+				t.Fatalf("unexpected top term of %s %+v", topic, tw)
+			}
+		}
+	}
+
+	again, err := tfidf.Cluster(ctx, ClusterOptions{K: 3, TopTerms: 3})
+	if err != nil || !reflect.DeepEqual(again.Assignments, res.Assignments) || math.Abs(again.Inertia-res.Inertia) > 1e-9 {
+		t.Fatalf("expected the same result with the same seed, got %+v, %v", again, err)
+	}
+}
+
+func TestClusterInvalidK(t *testing.T) {
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(context.Background(), testDocs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []int{0, 4} {
+		_, err := tfidf.Cluster(context.Background(), ClusterOptions{K: k})
+		if _, ok := err.(*ValidationError); !ok {
+			t.Fatalf("expected k %d to be invalid for 3 docs, got %v", k, err)
+		}
+	}
+	res, err := tfidf.Cluster(context.Background(), ClusterOptions{K: 3})
+	if err != nil || len(res.Clusters) != 3 {
+		t.Fatalf("expected one cluster per doc, got %+v, %v", res, err)
+	}
+	for _, c := range res.Clusters {
+		if c.Size != 1 {
+			t.Fatalf("expected one cluster per doc, got %+v", res)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package tfidf
+
+import (
+	"context"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+const (
+	simHashBits = 64
+
+	DefaultDuplicateThreshold = 0.9
+)
+
+type DuplicatePolicy string
+
+const (
+	// DuplicateAllow stores near-duplicates as usual
+	DuplicateAllow DuplicatePolicy = ""
+	// DuplicateReject skips docs similar to an existing doc with a different id
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateReplace overwrites the existing doc they duplicate, keeping its id
+	DuplicateReplace DuplicatePolicy = "replace"
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(s); p {
+	case DuplicateAllow, DuplicateReject, DuplicateReplace:
+		return p, nil
+	default:
+		return "", invalidf("on_duplicate", "unsupported duplicate policy %q", s)
+	}
+}
+
+type Duplicate struct {
+	DocID       string  `json:"doc_id"`
+	DuplicateOf string  `json:"duplicate_of"`
+	Similarity  float64 `json:"similarity"`
+}
+
+type UpsertResult struct {
+	Upserted int         `json:"upserted"`
+	Rejected []Duplicate `json:"rejected,omitempty"`
+	Replaced []Duplicate `json:"replaced,omitempty"`
+}
+
+type DuplicateCluster struct {
+	DocIDs []string `json:"doc_ids"`
+	// lowest similarity among the linked pairs of the cluster
+	Similarity float64 `json:"similarity"`
+}
+
+// signatureMap keeps SimHash signatures of docs and the band index of the
+// configured threshold up to date, other thresholds are looked up on the fly
+type signatureMap struct {
+	sync.Mutex
+	m     map[string]uint64
+	n     int
+	index []map[uint64]set
+}
+
+func newSignatureMap(n int) *signatureMap {
+	sm := &signatureMap{
+		m:     make(map[string]uint64),
+		n:     n,
+		index: make([]map[uint64]set, n),
+	}
+	for b := range sm.index {
+		sm.index[b] = make(map[uint64]set)
+	}
+	return sm
+}
+
+// WithDuplicateThreshold sets the threshold whose band index is kept, lookups
+// by other thresholds scan signatures, DefaultDuplicateThreshold by default
+func WithDuplicateThreshold(threshold float64) Option {
+	return func(t *TFIDF) {
+		if threshold > 0 && threshold <= 1 {
+			t.sigs = newSignatureMap(bands(threshold))
+		}
+	}
+}
+
+func (sm *signatureMap) set(id string, sig uint64) {
+	if sm == nil {
+		return
+	}
+	defer sm.Unlock()
+	sm.Lock()
+	sm.unlink(id)
+	sm.m[id] = sig
+	link(sm.index, id, sig, sm.n)
+}
+
+// unlink removes the doc from the band index, the caller holds the lock
+func (sm *signatureMap) unlink(id string) {
+	sig, ok := sm.m[id]
+	if !ok {
+		return
+	}
+	for b := range sm.index {
+		key := band(sig, b, sm.n)
+		sm.index[b][key].del(id)
+		if len(sm.index[b][key]) == 0 {
+			delete(sm.index[b], key)
+		}
+	}
+}
+
+func link(index []map[uint64]set, id string, sig uint64, n int) {
+	for b := range index {
+		key := band(sig, b, n)
+		if index[b][key] == nil {
+			index[b][key] = make(set)
+		}
+		index[b][key].set(id)
+	}
+}
+
+// buckets copies signatures and the buckets of n bands holding several docs,
+// an index of another layout is built for the call and dropped
+func (sm *signatureMap) buckets(n int) (map[string]uint64, [][]string) {
+	defer sm.Unlock()
+	sm.Lock()
+	sigs := make(map[string]uint64, len(sm.m))
+	for id, sig := range sm.m {
+		sigs[id] = sig
+	}
+	index := sm.index
+	if n != sm.n {
+		index = make([]map[uint64]set, n)
+		for b := range index {
+			index[b] = make(map[uint64]set)
+		}
+		for id, sig := range sm.m {
+			link(index, id, sig, n)
+		}
+	}
+	res := make([][]string, 0)
+	for _, buckets := range index {
+		for _, members := range buckets {
+			if len(members) > 1 {
+				res = append(res, members.members())
+			}
+		}
+	}
+	return sigs, res
+}
+
+// bands is the number of bands docs within the threshold share at least one
+// of by pigeonhole principle, so that looking up bands misses no pair
+func bands(threshold float64) int {
+	return int((1-threshold)*simHashBits) + 1
+}
+
+// band returns the bits of the signature in band b of n bands
+func band(sig uint64, b, n int) uint64 {
+	shift := uint(b * simHashBits / n)
+	width := uint((b+1)*simHashBits/n) - shift
+	mask := ^uint64(0)
+	if width < simHashBits {
+		mask = uint64(1)<<width - 1
+	}
+	return (sig >> shift) & mask
+}
+
+// simHash weights terms by their boosted counts rather than TF-IDF, so that
+// signatures stay valid while IDF drifts with the corpus.
+func (t *TFIDF) simHash(doc Doc) uint64 {
+	var acc [simHashBits]float64
+	terms := t.terms(doc)
+	for i := range terms {
+		h := fnv.New64a()
+		h.Write([]byte(terms[i].value))
+		sum := h.Sum64()
+		weight := t.fieldBoost(terms[i].field)
+		for b := 0; b < simHashBits; b++ {
+			if sum&(1<<uint(b)) != 0 {
+				acc[b] += weight
+			} else {
+				acc[b] -= weight
+			}
+		}
+	}
+	var sig uint64
+	for b := 0; b < simHashBits; b++ {
+		if acc[b] > 0 {
+			sig |= 1 << uint(b)
+		}
+	}
+	return sig
+}
+
+func simHashSimilarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/simHashBits
+}
+
+// nearestDuplicate finds the most similar stored doc with another id among
+// docs sharing a band with the doc, or among all docs for another threshold
+func (t *TFIDF) nearestDuplicate(doc Doc, threshold float64) (Duplicate, bool) {
+	sig := t.simHash(doc)
+	n := bands(threshold)
+	best := Duplicate{DocID: doc.ID}
+	found := false
+	check := func(id string) {
+		if id == doc.ID {
+			return
+		}
+		sim := simHashSimilarity(sig, t.sigs.m[id])
+		if sim < threshold {
+			return
+		}
+		if !found || sim > best.Similarity || (sim == best.Similarity && id < best.DuplicateOf) {
+			best.DuplicateOf = id
+			best.Similarity = sim
+			found = true
+		}
+	}
+	t.sigs.Lock()
+	defer t.sigs.Unlock()
+	if n != t.sigs.n {
+		for id := range t.sigs.m {
+			check(id)
+		}
+		return best, found
+	}
+	for b := range t.sigs.index {
+		for id := range t.sigs.index[b][band(sig, b, n)] {
+			check(id)
+		}
+	}
+	return best, found
+}
+
+// UpsertDocsWithPolicy checks every doc against stored docs by SimHash before upserting
+func (t *TFIDF) UpsertDocsWithPolicy(ctx context.Context, docs []Doc, policy DuplicatePolicy, threshold float64) (UpsertResult, error) {
+	res := UpsertResult{}
+	docs, err := t.analyzeChecked(docs)
+	if err != nil {
+		return res, err
+	}
+	for i := range docs {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if policy == DuplicateAllow {
+			t.upsertDoc(docs[i])
+			res.Upserted++
+			continue
+		}
+		dup, ok := t.nearestDuplicate(docs[i], threshold)
+		if !ok {
+			t.upsertDoc(docs[i])
+			res.Upserted++
+			continue
+		}
+		switch policy {
+		case DuplicateReject:
+			res.Rejected = append(res.Rejected, dup)
+		case DuplicateReplace:
+			doc := docs[i]
+			doc.ID = dup.DuplicateOf
+			t.upsertDoc(doc)
+			res.Replaced = append(res.Replaced, dup)
+		}
+	}
+	return res, nil
+}
+
+// NearDuplicates groups docs whose SimHash similarity reaches threshold,
+// pairs are only compared within buckets of the band index.
+func (t *TFIDF) NearDuplicates(ctx context.Context, threshold float64) ([]DuplicateCluster, error) {
+	if threshold <= 0 || threshold > 1 {
+		return nil, invalidf("threshold", "threshold %v out of range (0, 1]", threshold)
+	}
+	sigs, buckets := t.sigs.buckets(bands(threshold))
+	ids := make([]string, 0, len(sigs))
+	for id := range sigs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	pos := make(map[string]int, len(ids))
+	for i, id := range ids {
+		pos[id] = i
+	}
+
+	parent := make([]int, len(ids))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	minSim := make(map[[2]int]float64)
+	checked := make(map[[2]int]struct{})
+
+	for _, members := range buckets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				pair := [2]int{pos[members[x]], pos[members[y]]}
+				if pair[0] > pair[1] {
+					pair[0], pair[1] = pair[1], pair[0]
+				}
+				if _, ok := checked[pair]; ok {
+					continue
+				}
+				checked[pair] = struct{}{}
+				sim := simHashSimilarity(sigs[ids[pair[0]]], sigs[ids[pair[1]]])
+				if sim < threshold {
+					continue
+				}
+				ra, rb := find(pair[0]), find(pair[1])
+				if ra != rb {
+					parent[rb] = ra
+				}
+				minSim[pair] = sim
+			}
+		}
+	}
+
+	groups := make(map[int]*DuplicateCluster)
+	for i := range ids {
+		root := find(i)
+		g, ok := groups[root]
+		if !ok {
+			g = &DuplicateCluster{Similarity: 1}
+			groups[root] = g
+		}
+		g.DocIDs = append(g.DocIDs, ids[i])
+	}
+	for pair, sim := range minSim {
+		g := groups[find(pair[0])]
+		if sim < g.Similarity {
+			g.Similarity = sim
+		}
+	}
+
+	res := make([]DuplicateCluster, 0)
+	for _, g := range groups {
+		if len(g.DocIDs) > 1 {
+			res = append(res, *g)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i].DocIDs) != len(res[j].DocIDs) {
+			return len(res[i].DocIDs) > len(res[j].DocIDs)
+		}
+		return res[i].DocIDs[0] < res[j].DocIDs[0]
+	})
+	return res, nil
+}
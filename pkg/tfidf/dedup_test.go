@@ -0,0 +1,121 @@
+package tfidf
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestUpsertDocsWithPolicy(t *testing.T) {
+	ctx := context.Background()
+	words := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"}
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(ctx, []Doc{
+		{ID: "1", Words: words},
+		{ID: "2", Words: []string{"x", "y", "z"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dup := Doc{ID: "3", Words: words}
+	res, err := tfidf.UpsertDocsWithPolicy(ctx, []Doc{dup}, DuplicateReject, DefaultDuplicateThreshold)
+	if err != nil || len(res.Rejected) != 1 || res.Rejected[0].DuplicateOf != "1" {
+		t.Fatalf("expected doc 3 rejected as duplicate of 1, got %+v, %v", res, err)
+	}
+
+	clusters, err := tfidf.NearDuplicates(ctx, DefaultDuplicateThreshold)
+	if err != nil || len(clusters) != 0 {
+		t.Fatalf("expected no clusters, got %v, %v", clusters, err)
+	}
+	tfidf.UpsertDocs(ctx, []Doc{dup})
+	clusters, err = tfidf.NearDuplicates(ctx, DefaultDuplicateThreshold)
+	if err != nil || len(clusters) != 1 || !reflect.DeepEqual(clusters[0].DocIDs, []string{"1", "3"}) {
+		t.Fatalf("expected docs 1 and 3 clustered, got %v, %v", clusters, err)
+	}
+
+	// the band index built by the lookups above follows deletes
+	tfidf.DeleteDoc("1")
+	replacement := Doc{ID: "4", Words: append(words[:len(words):len(words)], "m")}
+	res, err = tfidf.UpsertDocsWithPolicy(ctx, []Doc{replacement}, DuplicateReplace, DefaultDuplicateThreshold)
+	if err != nil || len(res.Replaced) != 1 || res.Replaced[0].DuplicateOf != "3" {
+		t.Fatalf("expected doc 4 to replace 3, got %+v, %v", res, err)
+	}
+	doc, _ := tfidf.GetDoc("3")
+	if len(doc.Words) != len(words)+1 || tfidf.DocCount() != 2 {
+		t.Fatalf("expected doc 3 replaced by the words of doc 4, got %v", doc.Words)
+	}
+}
+
+func TestNearDuplicatesThresholds(t *testing.T) {
+	ctx := context.Background()
+	tfidf := NewTFIDF(WithDuplicateThreshold(0.8))
+	docs := topicDocs(rand.New(rand.NewSource(1)), 200, 4, 20, 12)
+	if _, err := tfidf.UpsertDocs(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+	sigs := make([]uint64, len(docs))
+	for i := range docs {
+		sigs[i] = tfidf.simHash(docs[i])
+	}
+
+	for _, threshold := range []float64{0.6, 0.7, 0.8, 0.9} {
+		// clusters of every pair compared
+		parent := make(map[string]string)
+		var find func(string) string
+		find = func(id string) string {
+			if p, ok := parent[id]; ok && p != id {
+				parent[id] = find(p)
+				return parent[id]
+			}
+			return id
+		}
+		for i := range docs {
+			for j := i + 1; j < len(docs); j++ {
+				if simHashSimilarity(sigs[i], sigs[j]) >= threshold {
+					parent[find(docs[j].ID)] = find(docs[i].ID)
+				}
+			}
+		}
+		groups := make(map[string][]string)
+		for i := range docs {
+			root := find(docs[i].ID)
+			groups[root] = append(groups[root], docs[i].ID)
+		}
+		expected := make([][]string, 0)
+		for _, ids := range groups {
+			if len(ids) > 1 {
+				sort.Strings(ids)
+				expected = append(expected, ids)
+			}
+		}
+
+		clusters, err := tfidf.NearDuplicates(ctx, threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := make([][]string, len(clusters))
+		for i := range clusters {
+			actual[i] = clusters[i].DocIDs
+		}
+		for _, c := range [][][]string{expected, actual} {
+			sort.Slice(c, func(i, j int) bool { return c[i][0] < c[j][0] })
+		}
+		if len(expected) == 0 || !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("threshold %v: expected clusters %v, got %v", threshold, expected, actual)
+		}
+		for i := range docs {
+			dup, ok := tfidf.nearestDuplicate(docs[i], threshold)
+			if ok != (find(docs[i].ID) != docs[i].ID || len(groups[docs[i].ID]) > 1) ||
+				(ok && simHashSimilarity(sigs[i], tfidf.sigs.m[dup.DuplicateOf]) != dup.Similarity) {
+				t.Fatalf("threshold %v: unexpected duplicate of %s %+v", threshold, docs[i].ID, dup)
+			}
+		}
+	}
+	// only the layout of the configured threshold is kept
+	if len(tfidf.sigs.index) != bands(0.8) {
+		t.Fatalf("expected %d bands kept, got %d", bands(0.8), len(tfidf.sigs.index))
+	}
+}
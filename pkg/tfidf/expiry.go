@@ -0,0 +1,170 @@
+package tfidf
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	OpDelete = "delete"
+	OpExpire = "expire"
+)
+
+// ExpiryStats reports docs removed by expiry since the server started
+type ExpiryStats struct {
+	Expired      int        `json:"expired"`
+	ExpiringDocs int        `json:"expiring_docs"`
+	NextExpiry   *time.Time `json:"next_expiry,omitempty"`
+	LastSweep    *time.Time `json:"last_sweep,omitempty"`
+}
+
+// WithTTL expires docs upserted without an expiry timestamp after ttl
+func WithTTL(ttl time.Duration) Option {
+	return func(t *TFIDF) {
+		t.ttl = ttl
+	}
+}
+
+func (dm *docMap) delDoc(id string) {
+	if dm == nil {
+		return
+	}
+	defer dm.Unlock()
+	dm.Lock()
+	delete(dm.m, id)
+}
+
+func (sm *signatureMap) del(id string) {
+	if sm == nil {
+		return
+	}
+	defer sm.Unlock()
+	sm.Lock()
+	sm.unlink(id)
+	delete(sm.m, id)
+}
+
+// removeDoc moves the last doc into position i, the moved doc is returned
+// to fix its position in docMap
+func (p *persistentData) removeDoc(i int) (moved *Doc) {
+	defer p.Unlock()
+	p.Lock()
+	last := len(p.Docs) - 1
+	if i != last {
+		p.Docs[i] = p.Docs[last]
+		moved = &p.Docs[i]
+	}
+	p.Docs[last] = Doc{}
+	p.Docs = p.Docs[:last]
+	p.DocCount = len(p.Docs)
+	p.updated = true
+	return moved
+}
+
+// deleteDoc removes the doc from words, signatures and docs, the caller holds the lock
+func (t *TFIDF) deleteDoc(id, op string) bool {
+	i := t.dm.getDoc(id)
+	if i < 0 {
+		return false
+	}
+	terms := t.terms(t.pd.Docs[i])
+	for j := range terms {
+		w := t.wm.getWord(terms[j].value)
+		w.delDoc(id)
+		w.delFieldDoc(terms[j].field, id)
+	}
+	t.sigs.del(id)
+	t.related.remove(id)
+	t.ann.remove(id)
+	t.dm.delDoc(id)
+	if moved := t.pd.removeDoc(i); moved != nil {
+		t.dm.setDoc(moved.ID, i)
+	}
+	_, removed := changedWords(termValues(terms), nil)
+	t.feed.publish(Change{DocID: id, Op: op, Removed: removed})
+	return true
+}
+
+// DeleteDoc removes the doc, its words are kept in the vocabulary
+func (t *TFIDF) DeleteDoc(id string) bool {
+	defer t.Unlock()
+	t.Lock()
+	return t.deleteDoc(id, OpDelete)
+}
+
+// Expire removes docs expired by now and returns their ids
+func (t *TFIDF) Expire(now time.Time) []string {
+	defer t.Unlock()
+	t.Lock()
+	expired := make([]string, 0)
+	for i := range t.pd.Docs {
+		if at := t.pd.Docs[i].ExpiresAt; at != nil && !at.After(now) {
+			expired = append(expired, t.pd.Docs[i].ID)
+		}
+	}
+	for _, id := range expired {
+		t.deleteDoc(id, OpExpire)
+	}
+	t.expired += len(expired)
+	t.lastSweep = now
+	return expired
+}
+
+func (t *TFIDF) ExpiryStats() ExpiryStats {
+	defer t.Unlock()
+	t.Lock()
+	res := ExpiryStats{
+		Expired: t.expired,
+	}
+	if !t.lastSweep.IsZero() {
+		lastSweep := t.lastSweep
+		res.LastSweep = &lastSweep
+	}
+	for i := range t.pd.Docs {
+		at := t.pd.Docs[i].ExpiresAt
+		if at == nil {
+			continue
+		}
+		res.ExpiringDocs++
+		if res.NextExpiry == nil || at.Before(*res.NextExpiry) {
+			res.NextExpiry = at
+		}
+	}
+	return res
+}
+
+// StartSweeper expires docs every interval until stop is called
+func (s *Server) StartSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if expired := s.engine().Expire(now); len(expired) > 0 {
+					log.Printf("expired %d docs", len(expired))
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+func (s *Server) DeleteDoc(ctx *gin.Context) {
+	t, done := s.writer()
+	defer done()
+	if !t.DeleteDoc(ctx.Param("id")) {
+		abortWithError(ctx, http.StatusNotFound, ErrCodeNotFound, errors.New("doc not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, "ok")
+}
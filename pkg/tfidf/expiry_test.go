@@ -0,0 +1,74 @@
+package tfidf
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	now := time.Now().UTC()
+	past, future := now.Add(-time.Minute), now.Add(48*time.Hour)
+	tfidf := NewTFIDF(WithTTL(time.Hour))
+	_, err := tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "past", Words: []string{"a"}, ExpiresAt: &past},
+		{ID: "ttl", Words: []string{"b"}},
+		{ID: "future", Words: []string{"c"}, ExpiresAt: &future},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := tfidf.GetDoc("ttl")
+	if doc.ExpiresAt == nil || doc.ExpiresAt.Sub(now) < time.Hour-time.Minute || doc.ExpiresAt.Sub(now) > time.Hour+time.Minute {
+		t.Fatalf("expected the ttl doc to expire in an hour, got %v", doc.ExpiresAt)
+	}
+
+	if expired := tfidf.Expire(now); !reflect.DeepEqual(expired, []string{"past"}) {
+		t.Fatalf("expected only the expired doc deleted, got %v", expired)
+	}
+	expired := tfidf.Expire(now.Add(2 * time.Hour))
+	if !reflect.DeepEqual(expired, []string{"ttl"}) || tfidf.DocCount() != 1 {
+		t.Fatalf("expected the ttl doc deleted after an hour, got %v", expired)
+	}
+	stats := tfidf.ExpiryStats()
+	if stats.Expired != 2 || stats.ExpiringDocs != 1 || !stats.NextExpiry.Equal(future) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	ops := make([]string, 0)
+	for _, c := range tfidf.Changes(0).Changes {
+		if c.Op == OpExpire {
+			ops = append(ops, c.DocID)
+		}
+	}
+	sort.Strings(ops)
+	if !reflect.DeepEqual(ops, []string{"past", "ttl"}) {
+		t.Fatalf("expected expiries in the change feed, got %v", ops)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	past := time.Now().Add(-time.Second)
+	s := &Server{tfidf: NewTFIDF()}
+	_, err := s.tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "1", Words: []string{"a"}, ExpiresAt: &past},
+		{ID: "2", Words: []string{"b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := s.StartSweeper(time.Millisecond)
+	defer stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.tfidf.DocCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the expired doc swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := s.tfidf.GetDoc("2"); !ok {
+		t.Fatal("expected the doc without expiry kept")
+	}
+}
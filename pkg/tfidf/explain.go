@@ -0,0 +1,95 @@
+package tfidf
+
+import "context"
+
+const (
+	tfFormula        = "tf = boost * count / doc_length"
+	idfFormula       = "idf = ln(N / (df + 1))"
+	weightFormula    = "weight = tf * idf"
+	cosineFormula    = "contribution = query_weight * doc_weight, both vectors L2 normalized"
+	queryTermFormula = "score = boost * count / doc_length * idf"
+)
+
+// TermExplanation breaks a TF-IDF value down into its inputs
+type TermExplanation struct {
+	Word      string  `json:"word"`
+	Count     int     `json:"count"`
+	DocLength int     `json:"doc_length"`
+	Boost     float64 `json:"boost"`
+	TF        float64 `json:"tf"`
+	DF        int     `json:"df"`
+	N         int     `json:"n"`
+	IDF       float64 `json:"idf"`
+	// per-field IDF only counts docs containing the word in the same field
+	PerFieldIDF bool     `json:"per_field_idf,omitempty"`
+	Formula     []string `json:"formula"`
+	Weight      float64  `json:"weight"`
+	// the word this index-time synonym was expanded from
+	ExpandedFrom string `json:"expanded_from,omitempty"`
+}
+
+func (t *TFIDF) termDF(tm term) int {
+	w := t.wm.getWord(tm.value)
+	if t.perFieldIDF {
+		return w.fieldDocCount(tm.field)
+	}
+	return w.docCount()
+}
+
+// ExplainDocVector works like GetDocVector and explains every value
+func (t *TFIDF) ExplainDocVector(ctx context.Context, doc Doc) ([]*WordTFIDF, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	expanded := make(map[string]string)
+	doc = t.analyzeExpanded(doc, expanded)
+	if err := t.checkTerms("", doc); err != nil {
+		return nil, err
+	}
+	res := t.getDocVector(doc)
+	t.explain(doc, res, expanded)
+	return res, nil
+}
+
+// StoredDocVector returns the vector of a stored doc without upserting it,
+// optionally explaining every value
+func (t *TFIDF) StoredDocVector(id string, explain bool) ([]*WordTFIDF, bool) {
+	doc, ok := t.GetDoc(id)
+	if !ok {
+		return nil, false
+	}
+	res := t.vector(doc)
+	if explain {
+		t.explain(doc, res, nil)
+	}
+	return res, true
+}
+
+// explain fills in explanations of the vector of the analyzed doc
+func (t *TFIDF) explain(doc Doc, res []*WordTFIDF, expanded map[string]string) {
+	terms := t.terms(doc)
+	countMap := make(map[term]int)
+	for i := range terms {
+		countMap[terms[i]]++
+	}
+	n := t.DocCount()
+	for i := range terms {
+		boost := t.fieldBoost(terms[i].field)
+		tf := boost * float64(countMap[terms[i]]) / float64(len(terms))
+		idf := t.termIDF(terms[i])
+		res[i].Explanation = &TermExplanation{
+			Word:         terms[i].value,
+			Count:        countMap[terms[i]],
+			DocLength:    len(terms),
+			Boost:        boost,
+			TF:           tf,
+			DF:           t.termDF(terms[i]),
+			N:            n,
+			IDF:          idf,
+			PerFieldIDF:  t.perFieldIDF,
+			Formula:      []string{tfFormula, idfFormula, weightFormula},
+			Weight:       res[i].Value,
+			ExpandedFrom: expanded[terms[i].value],
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package tfidf
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestExplainDocVector(t *testing.T) {
+	tfidf := NewTFIDF(WithFieldBoosts(map[string]float64{"title": 2}))
+	if _, err := tfidf.UpsertDocs(context.Background(), testDocs()); err != nil {
+		t.Fatal(err)
+	}
+	vec, ok := tfidf.StoredDocVector("2", true)
+	if !ok || len(vec) != 3 {
+		t.Fatalf("unexpected vector %v", vec)
+	}
+	for _, v := range vec {
+		e := v.Explanation
+		if e == nil || e.DocLength != 3 || e.Count != 1 || e.N != 3 {
+			t.Fatalf("unexpected explanation %+v", e)
+		}
+		df := map[string]int{"banana": 2, "cherry": 1, "fruit": 1}[e.Word]
+		boost := map[string]float64{"": 1, "title": 2}[v.Field]
+		idf := math.Log(3 / float64(df+1))
+		if e.DF != df || e.Boost != boost || math.Abs(e.TF-boost/3) > 1e-12 || math.Abs(e.IDF-idf) > 1e-12 {
+			t.Fatalf("unexpected explanation of %s %+v", e.Word, e)
+		}
+		if math.Abs(e.TF*e.IDF-v.Value) > 1e-12 || e.Weight != v.Value {
+			t.Fatalf("expected weight tf * idf, got %+v of %v", e, v.Value)
+		}
+	}
+}
+
+func TestExplainSearch(t *testing.T) {
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "1", Words: []string{"apple", "banana", "cherry", "apple"}},
+		{ID: "2", Words: []string{"banana", "cherry", "durian"}},
+		{ID: "3", Words: []string{"egg"}},
+		{ID: "4", Words: []string{"fig", "grape"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, err := tfidf.Search(context.Background(), SearchRequest{
+		Doc:     Doc{Words: []string{"apple", "cherry", "durian"}},
+		Limit:   10,
+		Explain: true,
+	})
+	if err != nil || len(hits) != 2 {
+		t.Fatalf("expected docs 1 and 2, got %+v, %v", hits, err)
+	}
+	for _, hit := range hits {
+		sum := 0.0
+		for _, c := range hit.Contributions {
+			if math.Abs(c.QueryWeight*c.DocWeight-c.Contribution) > 1e-12 {
+				t.Fatalf("unexpected contribution %+v", c)
+			}
+			sum += c.Contribution
+		}
+		if len(hit.Contributions) == 0 || math.Abs(sum-hit.Score) > 1e-9 || hit.Formula != cosineFormula {
+			t.Fatalf("expected contributions adding up to %v, got %+v", hit.Score, hit)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package tfidf
+
+import (
+	"sort"
+	"sync"
+)
+
+// defaultField is the field name of `Doc.Words`
+const defaultField = ""
+
+// WithFieldBoosts sets the weight of each field when computing TF,
+// fields absent from boosts are weighted 1.
+func WithFieldBoosts(boosts map[string]float64) Option {
+	return func(t *TFIDF) {
+		t.fieldBoosts = make(map[string]float64, len(boosts))
+		for k, v := range boosts {
+			t.fieldBoosts[k] = v
+		}
+	}
+}
+
+// WithPerFieldIDF makes IDF computed from the documents containing the word
+// in the same field instead of in any field.
+func WithPerFieldIDF(enabled bool) Option {
+	return func(t *TFIDF) {
+		t.perFieldIDF = enabled
+	}
+}
+
+func (t *TFIDF) fieldBoost(field string) float64 {
+	boost, ok := t.fieldBoosts[field]
+	if !ok {
+		return 1
+	}
+	return boost
+}
+
+// fieldNames returns names of non-empty fields of the document in a stable order,
+// the default field comes first.
+func (d Doc) fieldNames() []string {
+	names := make([]string, 0, len(d.Fields)+1)
+	if len(d.Words) > 0 {
+		names = append(names, defaultField)
+	}
+	keys := make([]string, 0, len(d.Fields))
+	for k := range d.Fields {
+		if k == defaultField {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return append(names, keys...)
+}
+
+func (d Doc) fieldWords(field string) []string {
+	if field == defaultField {
+		return d.Words
+	}
+	return d.Fields[field]
+}
+
+func copyFields(fields map[string][]string) map[string][]string {
+	if fields == nil {
+		return nil
+	}
+	res := make(map[string][]string, len(fields))
+	for k, v := range fields {
+		words := make([]string, len(v))
+		copy(words, v)
+		res[k] = words
+	}
+	return res
+}
+
+type fieldDocSets struct {
+	sync.Mutex
+	m map[string]*docSet
+}
+
+func newFieldDocSets() *fieldDocSets {
+	return &fieldDocSets{
+		m: make(map[string]*docSet),
+	}
+}
+
+func (f *fieldDocSets) get(field string) *docSet {
+	if f == nil {
+		return nil
+	}
+	defer f.Unlock()
+	f.Lock()
+	return f.m[field]
+}
+
+func (f *fieldDocSets) append(field, docID string) {
+	if f == nil {
+		return
+	}
+	defer f.Unlock()
+	f.Lock()
+	s, ok := f.m[field]
+	if !ok {
+		s = newSet()
+		f.m[field] = s
+	}
+	s.append(docID)
+}
+
+func (f *fieldDocSets) del(field, docID string) {
+	if f == nil {
+		return
+	}
+	defer f.Unlock()
+	f.Lock()
+	s, ok := f.m[field]
+	if !ok {
+		return
+	}
+	s.del(docID)
+	if s.size() == 0 {
+		delete(f.m, field)
+	}
+}
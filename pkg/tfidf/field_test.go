@@ -0,0 +1,58 @@
+package tfidf
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func fieldTestTFIDF(t *testing.T, opts ...Option) *TFIDF {
+	t.Helper()
+	tfidf := NewTFIDF(opts...)
+	_, err := tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "a", Words: []string{"pasta", "sauce", "cheese"}, Fields: map[string][]string{"title": {"go"}}},
+		{ID: "b", Words: []string{"go", "sauce", "cheese"}, Fields: map[string][]string{"title": {"pasta"}}},
+		{ID: "c", Words: []string{"rice", "bean"}},
+		{ID: "d", Words: []string{"tea"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tfidf
+}
+
+func TestFieldBoosts(t *testing.T) {
+	req := SearchRequest{Doc: Doc{Words: []string{"go"}}, Limit: 10}
+
+	// the same words in other fields score the same without boosts
+	hits, err := fieldTestTFIDF(t).Search(context.Background(), req)
+	if err != nil || len(hits) != 2 || math.Abs(hits[0].Score-hits[1].Score) > 1e-9 {
+		t.Fatalf("expected docs a and b tied, got %+v, %v", hits, err)
+	}
+	ids := searchIDs(t, fieldTestTFIDF(t, WithFieldBoosts(map[string]float64{"title": 3})), req)
+	if !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Fatalf("expected the title match first, got %v", ids)
+	}
+	ids = searchIDs(t, fieldTestTFIDF(t, WithFieldBoosts(map[string]float64{"title": 0.2})), req)
+	if !reflect.DeepEqual(ids, []string{"b", "a"}) {
+		t.Fatalf("expected the title match last, got %v", ids)
+	}
+}
+
+func TestPerFieldIDF(t *testing.T) {
+	tfidf := fieldTestTFIDF(t, WithPerFieldIDF(true))
+	// go is in 2 of 4 docs but in the title of one only
+	if idf := tfidf.IDF("go"); math.Abs(idf-math.Log(4.0/3)) > 1e-9 {
+		t.Fatalf("unexpected idf %v", idf)
+	}
+	if idf := tfidf.FieldIDF("title", "go"); math.Abs(idf-math.Log(4.0/2)) > 1e-9 {
+		t.Fatalf("unexpected title idf %v", idf)
+	}
+	if idf := tfidf.termIDF(term{value: "sauce", field: defaultField}); math.Abs(idf-math.Log(4.0/3)) > 1e-9 {
+		t.Fatalf("unexpected idf of sauce in words %v", idf)
+	}
+	if idf := tfidf.termIDF(term{value: "pasta", field: "title"}); math.Abs(idf-math.Log(4.0/2)) > 1e-9 {
+		t.Fatalf("unexpected idf of pasta in title %v", idf)
+	}
+}
@@ -0,0 +1,316 @@
+package tfidf
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	// IssueCountMismatch means DocCount or WordCount differs from the stored docs or words
+	IssueCountMismatch = "count_mismatch"
+	// IssueDuplicateDoc means a doc id is stored more than once, N counts it twice
+	IssueDuplicateDoc = "duplicate_doc"
+	// IssueEmptyDocID means a doc is stored without id and can never be looked up
+	IssueEmptyDocID = "empty_doc_id"
+	// IssueDuplicateWord means a word is stored at more than one index
+	IssueDuplicateWord = "duplicate_word"
+	// IssueOrphanWord means a term of a doc is missing from Words
+	IssueOrphanWord = "orphan_word"
+	// IssueIndexGap means WordOrders does not line up with Words
+	IssueIndexGap = "index_gap"
+	// IssueNGramMismatch means the snapshot is generated with another n-gram range
+	IssueNGramMismatch = "ngram_mismatch"
+	// IssueInvalidDoc means a stored doc would be rejected by /upsert_docs
+	IssueInvalidDoc = "invalid_doc"
+	// IssueUnusedWord means no doc uses a stored word, which is expected
+	// after deletes since words keep their indexes
+	IssueUnusedWord = "unused_word"
+	// IssueStaleLSA means the lsa model is fitted to other word indexes
+	IssueStaleLSA = "stale_lsa"
+)
+
+// maxLoggedIssues limits the issues logged when loading a snapshot
+const maxLoggedIssues = 10
+
+type Issue struct {
+	Kind string `json:"kind"`
+	// index of the doc or word the issue is found at, -1 for the whole snapshot
+	Index   int    `json:"index"`
+	DocID   string `json:"doc_id,omitempty"`
+	Word    string `json:"word,omitempty"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return i.Kind + ": " + i.Message
+}
+
+// CheckSnapshot verifies the snapshot against the n-gram range of t, an
+// empty result means loading it yields the same vocabulary and counts
+func (t *TFIDF) CheckSnapshot(s *Snapshot) []Issue {
+	issues := make([]Issue, 0)
+	if err := t.checkNGramRange(s); err != nil {
+		issues = append(issues, Issue{
+			Kind:    IssueNGramMismatch,
+			Index:   -1,
+			Message: err.Error(),
+		})
+	}
+	if s.DocCount != len(s.Docs) {
+		issues = append(issues, Issue{
+			Kind:    IssueCountMismatch,
+			Index:   -1,
+			Message: fmt.Sprintf("doc count is %d, %d docs stored", s.DocCount, len(s.Docs)),
+		})
+	}
+	if s.WordCount != len(s.Words) {
+		issues = append(issues, Issue{
+			Kind:    IssueCountMismatch,
+			Index:   -1,
+			Message: fmt.Sprintf("word count is %d, %d words stored", s.WordCount, len(s.Words)),
+		})
+	}
+
+	words := make(map[string]int, len(s.Words))
+	for i, w := range s.Words {
+		if j, ok := words[w]; ok {
+			issues = append(issues, Issue{
+				Kind:    IssueDuplicateWord,
+				Index:   i,
+				Word:    w,
+				Message: fmt.Sprintf("word %q at index %d is already stored at index %d", w, i, j),
+			})
+			continue
+		}
+		words[w] = i
+	}
+	// snapshots written before n-grams have no orders at all
+	if len(s.WordOrders) > 0 {
+		for i := len(s.WordOrders); i < len(s.Words); i++ {
+			issues = append(issues, Issue{
+				Kind:    IssueIndexGap,
+				Index:   i,
+				Word:    s.Words[i],
+				Message: fmt.Sprintf("word %q at index %d has no n-gram order", s.Words[i], i),
+			})
+		}
+	}
+	if len(s.WordOrders) > len(s.Words) {
+		issues = append(issues, Issue{
+			Kind:    IssueIndexGap,
+			Index:   len(s.Words),
+			Message: fmt.Sprintf("%d n-gram orders stored for %d words", len(s.WordOrders), len(s.Words)),
+		})
+	}
+	for i := 0; i < len(s.WordOrders) && i < len(s.Words); i++ {
+		if s.WordOrders[i] < 1 {
+			issues = append(issues, Issue{
+				Kind:    IssueIndexGap,
+				Index:   i,
+				Word:    s.Words[i],
+				Message: fmt.Sprintf("word %q at index %d has n-gram order %d", s.Words[i], i, s.WordOrders[i]),
+			})
+		}
+	}
+
+	docs := make(map[string]int, len(s.Docs))
+	orphans := make(map[string]bool)
+	for i := range s.Docs {
+		id := s.Docs[i].ID
+		if id == "" {
+			issues = append(issues, Issue{
+				Kind:    IssueEmptyDocID,
+				Index:   i,
+				Message: fmt.Sprintf("doc at index %d has no id", i),
+			})
+		} else if j, ok := docs[id]; ok {
+			issues = append(issues, Issue{
+				Kind:    IssueDuplicateDoc,
+				Index:   i,
+				DocID:   id,
+				Message: fmt.Sprintf("doc %q at index %d is already stored at index %d", id, i, j),
+			})
+		}
+		docs[id] = i
+		if err := t.checkDoc(s.Docs[i]); err != nil {
+			issues = append(issues, Issue{
+				Kind:    IssueInvalidDoc,
+				Index:   i,
+				DocID:   id,
+				Message: fmt.Sprintf("doc %q at index %d is invalid, %v", id, i, err),
+			})
+		}
+
+		for _, tm := range t.terms(s.Docs[i]) {
+			if _, ok := words[tm.value]; ok || orphans[tm.value] {
+				continue
+			}
+			orphans[tm.value] = true
+			issues = append(issues, Issue{
+				Kind:    IssueOrphanWord,
+				Index:   i,
+				DocID:   id,
+				Word:    tm.value,
+				Message: fmt.Sprintf("word %q of doc %q is not stored", tm.value, id),
+			})
+		}
+	}
+	return issues
+}
+
+// checkDoc validates a stored doc like /upsert_docs, missing ids are
+// reported on their own
+func (t *TFIDF) checkDoc(doc Doc) error {
+	if err := doc.validate("", false); err != nil {
+		return err
+	}
+	return t.checkTerms("", doc)
+}
+
+// UnusedWords lists stored words no doc uses. They are no integrity issue,
+// deleted docs leave them behind, prune drops them.
+func (t *TFIDF) UnusedWords(s *Snapshot) []Issue {
+	used := make(set)
+	for i := range s.Docs {
+		for _, tm := range t.terms(s.Docs[i]) {
+			used.set(tm.value)
+		}
+	}
+	issues := make([]Issue, 0)
+	for i, w := range s.Words {
+		if !used.exist(w) {
+			issues = append(issues, Issue{
+				Kind:    IssueUnusedWord,
+				Index:   i,
+				Word:    w,
+				Message: fmt.Sprintf("word %q at index %d is used by no doc", w, i),
+			})
+		}
+	}
+	return issues
+}
+
+// CheckLSA verifies that the lsa model is fitted to the word indexes of the
+// snapshot, models saved without words are only checked for missing indexes
+func CheckLSA(s *Snapshot, m *LSAModel) []Issue {
+	return checkLSA(m, func(index int) (string, bool) {
+		if index < 0 || index >= len(s.Words) {
+			return "", false
+		}
+		return s.Words[index], true
+	})
+}
+
+// checkLSA reports the first word index the model disagrees on
+func checkLSA(m *LSAModel, word func(index int) (string, bool)) []Issue {
+	for i, index := range m.Terms {
+		w, ok := word(index)
+		if !ok {
+			return []Issue{{
+				Kind:    IssueStaleLSA,
+				Index:   index,
+				Message: fmt.Sprintf("word index %d of the lsa model is not stored", index),
+			}}
+		}
+		if len(m.Words) > i && m.Words[i] != w {
+			return []Issue{{
+				Kind:    IssueStaleLSA,
+				Index:   index,
+				Word:    w,
+				Message: fmt.Sprintf("word index %d is %q in the lsa model and %q in the snapshot", index, m.Words[i], w),
+			}}
+		}
+	}
+	return nil
+}
+
+// RepairSnapshot returns a copy of the snapshot without the issues found by
+// CheckSnapshot, the last of duplicate docs and the first of duplicate words
+// are kept, invalid docs are dropped, orphan words are appended and counts
+// are recomputed. ANN signatures are dropped when word indexes change, lsa
+// models fitted before then are stale, see CheckLSA.
+func (t *TFIDF) RepairSnapshot(s *Snapshot) (*Snapshot, []Issue) {
+	issues := t.CheckSnapshot(s)
+	if len(issues) == 0 {
+		return s, issues
+	}
+
+	res := &Snapshot{
+		Docs:       make([]Doc, 0, len(s.Docs)),
+		Words:      make([]string, 0, len(s.Words)),
+		WordOrders: make([]int, 0, len(s.Words)),
+		NGramMin:   t.ngramMin,
+		NGramMax:   t.ngramMax,
+		Upserts:    s.Upserts,
+		Growth:     s.Growth,
+		Classifier: s.Classifier,
+		ANN:        s.ANN,
+		Related:    s.Related,
+	}
+
+	last := make(map[string]int, len(s.Docs))
+	for i := range s.Docs {
+		last[s.Docs[i].ID] = i
+	}
+	for i := range s.Docs {
+		if s.Docs[i].ID != "" && last[s.Docs[i].ID] == i && t.checkDoc(s.Docs[i]) == nil {
+			res.Docs = append(res.Docs, s.Docs[i])
+		}
+	}
+
+	words := make(map[string]bool, len(s.Words))
+	appendWord := func(w string, order int) {
+		if words[w] {
+			return
+		}
+		words[w] = true
+		res.Words = append(res.Words, w)
+		res.WordOrders = append(res.WordOrders, order)
+	}
+	// orders of words still used by docs are known from their terms
+	orders := make(map[string]int)
+	for i := range res.Docs {
+		for _, tm := range t.terms(res.Docs[i]) {
+			orders[tm.value] = tm.order
+		}
+	}
+	for i, w := range s.Words {
+		order := orders[w]
+		if order == 0 && i < len(s.WordOrders) {
+			order = s.WordOrders[i]
+		}
+		if order < 1 {
+			order = 1
+		}
+		appendWord(w, order)
+	}
+	for i := range res.Docs {
+		for _, tm := range t.terms(res.Docs[i]) {
+			appendWord(tm.value, tm.order)
+		}
+	}
+
+	for i, w := range s.Words {
+		if i >= len(res.Words) || res.Words[i] != w {
+			res.ANN = nil
+			break
+		}
+	}
+
+	res.DocCount = len(res.Docs)
+	res.WordCount = len(res.Words)
+	return res, issues
+}
+
+// logIssues reports a snapshot loaded despite issues, which are kept as they
+// are until the snapshot is repaired
+func logIssues(issues []Issue) {
+	log.Printf("snapshot has %d integrity issues, run `tfidf fsck -repair` to fix them", len(issues))
+	for i := range issues {
+		if i == maxLoggedIssues {
+			log.Printf("... %d more", len(issues)-i)
+			break
+		}
+		log.Println(issues[i])
+	}
+}
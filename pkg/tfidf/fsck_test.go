@@ -0,0 +1,153 @@
+package tfidf
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func issueKinds(issues []Issue) []string {
+	kinds := make([]string, len(issues))
+	for i := range issues {
+		kinds[i] = issues[i].Kind
+	}
+	return kinds
+}
+
+func TestCheckSnapshotClean(t *testing.T) {
+	tfidf := NewTFIDF(WithNGramRange(1, 2))
+	tfidf.UpsertDocs(context.Background(), testDocs())
+	issues := tfidf.CheckSnapshot(tfidf.Snapshot())
+	if len(issues) != 0 {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+}
+
+func TestRepairSnapshot(t *testing.T) {
+	s := &Snapshot{
+		DocCount: 3,
+		Docs: []Doc{
+			{ID: "1", Words: []string{"apple", "banana"}},
+			{ID: "1", Words: []string{"cherry"}},
+			{Words: []string{"durian"}},
+		},
+		Words:      []string{"apple", "banana", "apple"},
+		WordOrders: []int{1, 0},
+		WordCount:  3,
+		Upserts:    3,
+	}
+	tfidf := NewTFIDF()
+	repaired, issues := tfidf.RepairSnapshot(s)
+	expected := []string{
+		IssueDuplicateWord, IssueIndexGap, IssueIndexGap,
+		IssueDuplicateDoc, IssueOrphanWord, IssueEmptyDocID, IssueOrphanWord,
+	}
+	if !reflect.DeepEqual(issueKinds(issues), expected) {
+		t.Fatalf("expected %v, got %v", expected, issues)
+	}
+
+	if len(repaired.Docs) != 1 || repaired.Docs[0].Words[0] != "cherry" || repaired.DocCount != 1 {
+		t.Fatalf("expected the last doc 1 to be kept, got %+v", repaired.Docs)
+	}
+	words := []string{"apple", "banana", "cherry"}
+	if !reflect.DeepEqual(repaired.Words, words) || repaired.WordCount != len(words) {
+		t.Fatalf("expected words %v, got %v", words, repaired.Words)
+	}
+	if !reflect.DeepEqual(repaired.WordOrders, []int{1, 1, 1}) {
+		t.Fatalf("unexpected orders %v", repaired.WordOrders)
+	}
+	if repaired.Upserts != s.Upserts {
+		t.Fatalf("expected upserts %d, got %d", s.Upserts, repaired.Upserts)
+	}
+	if issues := tfidf.CheckSnapshot(repaired); len(issues) != 0 {
+		t.Fatalf("repaired snapshot has issues %v", issues)
+	}
+}
+
+func TestNGramRangeMismatch(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(dir+"/data.json", dir+"/fd.json", 0600)
+	bigrams := NewTFIDF(WithNGramRange(1, 2))
+	bigrams.UpsertDocs(context.Background(), testDocs())
+	err := bigrams.SaveTo(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unigrams := NewTFIDF()
+	if err := unigrams.LoadFromStore(store); err == nil {
+		t.Fatal("expected loading with another n-gram range to fail")
+	}
+	s, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kinds := issueKinds(unigrams.CheckSnapshot(s)); len(kinds) == 0 || kinds[0] != IssueNGramMismatch {
+		t.Fatalf("expected an n-gram mismatch, got %v", kinds)
+	}
+	if err := NewTFIDF(WithNGramRange(1, 2)).LoadFromStore(store); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepairSnapshotIndexes(t *testing.T) {
+	ctx := context.Background()
+	tfidf := NewTFIDF()
+	tfidf.UpsertDocs(ctx, testDocs())
+	model, err := tfidf.FitLSA(ctx, LSAOptions{Components: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := tfidf.Snapshot()
+	if s.ANN == nil || len(CheckLSA(s, model)) != 0 {
+		t.Fatalf("expected signatures and a fresh lsa model, got %v", CheckLSA(s, model))
+	}
+
+	// an orphan word is appended at its old index
+	truncated := *s
+	truncated.Words = s.Words[:len(s.Words)-1]
+	truncated.WordOrders = s.WordOrders[:len(s.Words)-1]
+	truncated.WordCount--
+	repaired, _ := tfidf.RepairSnapshot(&truncated)
+	if repaired.ANN == nil || len(CheckLSA(repaired, model)) != 0 {
+		t.Fatal("expected signatures kept when indexes are unchanged")
+	}
+
+	// a duplicate word at the front shifts every index
+	shifted := *s
+	shifted.Words = append([]string{s.Words[1]}, s.Words...)
+	shifted.WordOrders = append([]int{1}, s.WordOrders...)
+	shifted.WordCount++
+	shifted.Docs = append(s.Docs[:len(s.Docs):len(s.Docs)], Doc{ID: "4", Words: []string{"apple", ""}})
+	shifted.DocCount++
+	repaired, issues := tfidf.RepairSnapshot(&shifted)
+	found := make(set)
+	for _, kind := range issueKinds(issues) {
+		found.set(kind)
+	}
+	if !found.exist(IssueDuplicateWord) || !found.exist(IssueInvalidDoc) {
+		t.Fatalf("expected a duplicate word and an invalid doc, got %v", issues)
+	}
+	if repaired.ANN != nil || len(repaired.Docs) != 3 {
+		t.Fatalf("expected signatures and the invalid doc dropped, got %+v", repaired)
+	}
+	if kinds := issueKinds(CheckLSA(repaired, model)); !reflect.DeepEqual(kinds, []string{IssueStaleLSA}) {
+		t.Fatalf("expected the lsa model stale, got %v", kinds)
+	}
+
+	filename := t.TempDir() + "/lsa.json"
+	if err := tfidf.SaveLSA(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewTFIDF()
+	loaded.loadSnapshot(repaired)
+	if err := loaded.LoadLSA(filename); err != nil || loaded.lsa.get() != nil {
+		t.Fatalf("expected the stale lsa model left out, got %v", err)
+	}
+
+	tfidf.DeleteDoc("3")
+	unused := tfidf.UnusedWords(tfidf.Snapshot())
+	if len(unused) != 1 || unused[0].Word != "durian" || len(tfidf.CheckSnapshot(tfidf.Snapshot())) != 0 {
+		t.Fatalf("expected durian unused without integrity issues, got %v", unused)
+	}
+}
@@ -0,0 +1,202 @@
+package tfidf
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// MaxFuzzyDistance bounds edit distances to keep BK-tree searches cheap
+	MaxFuzzyDistance     = 3
+	defaultFuzzyDistance = 2
+	defaultSuggestLimit  = 10
+)
+
+type Suggestion struct {
+	Word     string `json:"word"`
+	Distance int    `json:"distance"`
+	DF       int    `json:"df"`
+}
+
+// bkTree indexes unigrams of the vocabulary by Levenshtein distance
+type bkTree struct {
+	sync.Mutex
+	root *bkNode
+}
+
+type bkNode struct {
+	word     []rune
+	children map[int]*bkNode
+}
+
+func (bk *bkTree) add(s string) {
+	if bk == nil {
+		return
+	}
+	defer bk.Unlock()
+	bk.Lock()
+	word := []rune(s)
+	if bk.root == nil {
+		bk.root = &bkNode{word: word}
+		return
+	}
+	node := bk.root
+	for {
+		d := levenshtein(word, node.word, -1)
+		if d == 0 {
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{word: word}
+			return
+		}
+		node = child
+	}
+}
+
+type bkMatch struct {
+	word     string
+	distance int
+}
+
+// search collects words within maxDistance, triangle inequality prunes
+// children out of [d - maxDistance, d + maxDistance]. Distances are computed
+// up to 2 * maxDistance, beyond it d is only known to exceed the limit and
+// children are kept from limit + 1 - maxDistance on.
+func (bk *bkTree) search(s string, maxDistance int) []bkMatch {
+	if bk == nil {
+		return nil
+	}
+	defer bk.Unlock()
+	bk.Lock()
+	if bk.root == nil {
+		return nil
+	}
+	word := []rune(s)
+	limit := 2 * maxDistance
+	res := make([]bkMatch, 0)
+	stack := []*bkNode{bk.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := levenshtein(word, node.word, limit)
+		if d <= maxDistance {
+			res = append(res, bkMatch{word: string(node.word), distance: d})
+		}
+		for cd, child := range node.children {
+			if cd >= d-maxDistance && (d > limit || cd <= d+maxDistance) {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return res
+}
+
+// levenshtein computes the edit distance of runes, a non-negative limit
+// stops early and returns limit+1 once the distance exceeds it
+func levenshtein(a, b []rune, limit int) int {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	if limit >= 0 && len(a)-len(b) > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if limit >= 0 && rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Suggest finds unigrams of stored docs within maxDistance edits of the word,
+// ranked by document frequency and then by distance. The tree keeps words
+// of deleted docs, they are left out by their zero frequency.
+func (t *TFIDF) Suggest(s string, maxDistance, limit int) ([]Suggestion, error) {
+	if maxDistance < 0 || maxDistance > MaxFuzzyDistance {
+		return nil, invalidf("max_distance", "max_distance should be 0 to %d", MaxFuzzyDistance)
+	}
+	if limit <= 0 {
+		limit = defaultSuggestLimit
+	}
+	matches := t.bk.search(s, maxDistance)
+	res := make([]Suggestion, 0, len(matches))
+	for _, m := range matches {
+		df := t.wm.getWord(m.word).docCount()
+		if df == 0 {
+			continue
+		}
+		res = append(res, Suggestion{Word: m.word, Distance: m.distance, DF: df})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].DF != res[j].DF {
+			return res[i].DF > res[j].DF
+		}
+		if res[i].Distance != res[j].Distance {
+			return res[i].Distance < res[j].Distance
+		}
+		return res[i].Word < res[j].Word
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// expandFuzzy returns known unigrams within maxDistance edits of the word
+func (t *TFIDF) expandFuzzy(s string, maxDistance int) []string {
+	matches := t.bk.search(s, maxDistance)
+	res := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if t.wm.getWord(m.word).docCount() > 0 {
+			res = append(res, m.word)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// correctWords replaces unknown words by their best suggestion within maxDistance,
+// words without suggestion are kept
+func (t *TFIDF) correctWords(words []string, maxDistance int) []string {
+	res := make([]string, len(words))
+	for i, w := range words {
+		res[i] = w
+		if t.wm.getWord(w).docCount() > 0 {
+			continue
+		}
+		suggestions, err := t.Suggest(w, maxDistance, 1)
+		if err == nil && len(suggestions) > 0 {
+			res[i] = suggestions[0].Word
+		}
+	}
+	return res
+}
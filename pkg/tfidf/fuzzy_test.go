@@ -0,0 +1,113 @@
+package tfidf
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		limit int
+		d     int
+	}{
+		{"kitten", "sitting", -1, 3},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 1, 2},
+		{"", "abc", -1, 3},
+		{"abcdef", "ab", 2, 3},
+		{"東京都", "京都", -1, 1},
+	}
+	for _, c := range cases {
+		if d := levenshtein([]rune(c.a), []rune(c.b), c.limit); d != c.d {
+			t.Fatalf("levenshtein(%q, %q, %d) expected %d, got %d", c.a, c.b, c.limit, c.d, d)
+		}
+	}
+}
+
+func TestBKTreeSearch(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	words := make([]string, 2000)
+	bk := &bkTree{}
+	for i := range words {
+		w := make([]byte, 3+rnd.Intn(6))
+		for j := range w {
+			w[j] = byte('a' + rnd.Intn(6))
+		}
+		words[i] = string(w)
+		bk.add(words[i])
+	}
+	for i := 0; i < 50; i++ {
+		q := words[rnd.Intn(len(words))] + string(rune('a'+rnd.Intn(6)))
+		for r := 0; r <= MaxFuzzyDistance; r++ {
+			expected := make(map[string]int)
+			for _, w := range words {
+				if d := levenshtein([]rune(q), []rune(w), -1); d <= r {
+					expected[w] = d
+				}
+			}
+			actual := make(map[string]int)
+			for _, m := range bk.search(q, r) {
+				actual[m.word] = m.distance
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("search(%q, %d) expected %v, got %v", q, r, expected, actual)
+			}
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	tfidf := NewTFIDF()
+	docs := make([]Doc, 0)
+	for i := 0; i < 3; i++ {
+		docs = append(docs, Doc{ID: fmt.Sprint("apple", i), Words: []string{"apple"}})
+	}
+	docs = append(docs,
+		Doc{ID: "ample", Words: []string{"ample"}},
+		Doc{ID: "apply", Words: []string{"apply", "maple"}},
+		Doc{ID: "apples", Words: []string{"apples"}},
+	)
+	if _, err := tfidf.UpsertDocs(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := tfidf.Suggest("appel", 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Suggestion{
+		{Word: "apple", Distance: 2, DF: 3},
+		{Word: "apples", Distance: 2, DF: 1},
+		{Word: "apply", Distance: 2, DF: 1},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("expected %v, got %v", expected, res)
+	}
+	res, _ = tfidf.Suggest("apple", 1, 2)
+	words := []string{res[0].Word, res[1].Word}
+	if !reflect.DeepEqual(words, []string{"apple", "ample"}) {
+		t.Fatalf("expected the most frequent words first, got %v", res)
+	}
+	if _, err := tfidf.Suggest("apple", MaxFuzzyDistance+1, 1); err == nil {
+		t.Fatal("expected max_distance above the bound to be invalid")
+	}
+
+	// words of deleted docs stay in the tree but are not suggested
+	tfidf.DeleteDoc("apply")
+	res, _ = tfidf.Suggest("appel", 2, 10)
+	for _, s := range res {
+		if s.Word == "apply" {
+			t.Fatalf("unexpected suggestion of a deleted word %v", res)
+		}
+	}
+
+	// known words are kept, deleted ones are corrected like unknown ones
+	corrected := tfidf.correctWords([]string{"aple", "apply", "ample", "zzz"}, 1)
+	if !reflect.DeepEqual(corrected, []string{"apple", "apple", "ample", "zzz"}) {
+		t.Fatalf("unexpected corrections %v", corrected)
+	}
+}
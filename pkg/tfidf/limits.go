@@ -0,0 +1,131 @@
+package tfidf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusClientClosedRequest follows nginx for requests the client gave up on
+const StatusClientClosedRequest = 499
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// Limits bound the requests of a Server, zero values disable a limit
+type Limits struct {
+	// Timeout bounds every route without an entry in RouteTimeouts,
+	// streaming routes such as /changes are only bounded by RouteTimeouts
+	Timeout time.Duration
+	// RouteTimeouts by method and path as routed, e.g. "POST /lsa/fit"
+	RouteTimeouts map[string]time.Duration
+	// MaxBodySize bounds every route without an entry in RouteMaxBodySizes
+	MaxBodySize int64
+	// RouteMaxBodySizes by method and path, e.g. "POST /admin/restore"
+	RouteMaxBodySizes map[string]int64
+}
+
+// SetLimits applies to handlers registered afterwards
+func (s *Server) SetLimits(l Limits) error {
+	known := make(map[string]bool)
+	for _, r := range s.routes() {
+		known[r.method+" "+r.path] = true
+	}
+	for key := range l.RouteTimeouts {
+		if !known[key] {
+			return fmt.Errorf("timeout of unknown route %q", key)
+		}
+	}
+	for key := range l.RouteMaxBodySizes {
+		if !known[key] {
+			return fmt.Errorf("max body size of unknown route %q", key)
+		}
+	}
+	s.limits = l
+	return nil
+}
+
+// limit bounds the request body and the request context, handlers pass the
+// context to TFIDF which stops between docs once it is done
+func (s *Server) limit(r route) gin.HandlerFunc {
+	timeout := s.limits.Timeout
+	if r.streaming {
+		timeout = 0
+	}
+	if d, ok := s.limits.RouteTimeouts[r.method+" "+r.path]; ok {
+		timeout = d
+	}
+	maxBodySize := s.limits.MaxBodySize
+	if n, ok := s.limits.RouteMaxBodySizes[r.method+" "+r.path]; ok {
+		maxBodySize = n
+	}
+
+	return func(ctx *gin.Context) {
+		if maxBodySize > 0 && ctx.Request.Body != nil {
+			err := fmt.Errorf("%w, limit is %d bytes", errBodyTooLarge, maxBodySize)
+			if ctx.Request.ContentLength > maxBodySize {
+				abortWithError(ctx, http.StatusRequestEntityTooLarge, ErrCodeTooLarge, err)
+				return
+			}
+			ctx.Request.Body = &limitedBody{ReadCloser: ctx.Request.Body, remaining: maxBodySize, err: err}
+		}
+		if timeout > 0 {
+			c, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+			defer cancel()
+			ctx.Request = ctx.Request.WithContext(c)
+		}
+		ctx.Next()
+	}
+}
+
+// limitedBody fails reads past the limit instead of truncating the body
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, b.err
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = -1
+		return n, b.err
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// abortIfDone responds 413 when the body exceeded the limit, 499 when the
+// client went away and 503 when the route timed out, it reports whether err was any
+func abortIfDone(ctx *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		abortWithError(ctx, http.StatusRequestEntityTooLarge, ErrCodeTooLarge, err)
+	case errors.Is(err, context.Canceled):
+		abortWithError(ctx, StatusClientClosedRequest, ErrCodeCanceled, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		abortWithError(ctx, http.StatusServiceUnavailable, ErrCodeTimeout, err)
+	default:
+		return false
+	}
+	return true
+}
+
+// abortFailed responds errors of TFIDF methods, which are invalid parameters
+// unless a limit was hit
+func abortFailed(ctx *gin.Context, err error) {
+	if !abortIfDone(ctx, err) {
+		abortInvalid(ctx, err)
+	}
+}
@@ -0,0 +1,167 @@
+package tfidf
+
+import "sort"
+
+const (
+	SortByIndex  = "index"
+	SortByID     = "id"
+	SortByLength = "length"
+	SortByWord   = "word"
+	SortByDF     = "df"
+	SortByDFDesc = "-df"
+)
+
+type WordInfo struct {
+	Word  string  `json:"word"`
+	Index int     `json:"index"`
+	Order int     `json:"order"`
+	DF    int     `json:"df"`
+	IDF   float64 `json:"idf"`
+	// document frequency of the word in each field
+	FieldDF map[string]int `json:"field_df,omitempty"`
+}
+
+type DocPage struct {
+	Total int   `json:"total"`
+	Docs  []Doc `json:"docs"`
+}
+
+type WordPage struct {
+	Total int        `json:"total"`
+	Words []WordInfo `json:"words"`
+}
+
+func (t *TFIDF) GetDoc(id string) (Doc, bool) {
+	defer t.Unlock()
+	t.Lock()
+	doc := t.getDoc(id)
+	if doc == nil {
+		return Doc{}, false
+	}
+	return *doc, true
+}
+
+func (t *TFIDF) LookupWord(s string) (WordInfo, bool) {
+	w := t.wm.getWord(s)
+	if w == nil {
+		return WordInfo{}, false
+	}
+	return t.wordInfo(w), true
+}
+
+func (t *TFIDF) WordByIndex(i int) (WordInfo, bool) {
+	s, ok := t.wordValue(i)
+	if !ok {
+		return WordInfo{}, false
+	}
+	return t.LookupWord(s)
+}
+
+func (t *TFIDF) wordInfo(w *word) WordInfo {
+	info := WordInfo{
+		Word:  w.value,
+		Index: w.index,
+		Order: w.order,
+		DF:    w.docCount(),
+		IDF:   t.IDF(w.value),
+	}
+	if w.fieldDocs == nil {
+		return info
+	}
+	w.fieldDocs.Lock()
+	defer w.fieldDocs.Unlock()
+	if len(w.fieldDocs.m) == 1 && w.fieldDocs.m[defaultField] != nil {
+		return info
+	}
+	info.FieldDF = make(map[string]int, len(w.fieldDocs.m))
+	for field, s := range w.fieldDocs.m {
+		info.FieldDF[field] = s.size()
+	}
+	return info
+}
+
+// ListDocs sorts docs by index, id or length before paging
+func (t *TFIDF) ListDocs(offset, limit int, sortBy string) (DocPage, error) {
+	docs := t.storedDocs()
+
+	switch sortBy {
+	case "", SortByIndex:
+	case SortByID:
+		sort.SliceStable(docs, func(i, j int) bool {
+			return docs[i].ID < docs[j].ID
+		})
+	case SortByLength:
+		lengths := make(map[string]int, len(docs))
+		for i := range docs {
+			lengths[docs[i].ID] = len(t.terms(docs[i]))
+		}
+		sort.SliceStable(docs, func(i, j int) bool {
+			return lengths[docs[i].ID] > lengths[docs[j].ID]
+		})
+	default:
+		return DocPage{}, invalidf("sort", "unsupported sort %q", sortBy)
+	}
+
+	start, end := pageRange(len(docs), offset, limit)
+	return DocPage{
+		Total: len(docs),
+		Docs:  docs[start:end],
+	}, nil
+}
+
+// ListWords sorts words by index, word, df or -df before paging
+func (t *TFIDF) ListWords(offset, limit int, sortBy string) (WordPage, error) {
+	t.pd.Lock()
+	values := make([]string, len(t.pd.Words))
+	copy(values, t.pd.Words)
+	t.pd.Unlock()
+
+	words := make([]*word, 0, len(values))
+	for i := range values {
+		if w := t.wm.getWord(values[i]); w != nil {
+			words = append(words, w)
+		}
+	}
+
+	switch sortBy {
+	case "", SortByIndex:
+	case SortByWord:
+		sort.SliceStable(words, func(i, j int) bool {
+			return words[i].value < words[j].value
+		})
+	case SortByDF:
+		sort.SliceStable(words, func(i, j int) bool {
+			return words[i].docCount() < words[j].docCount()
+		})
+	case SortByDFDesc:
+		sort.SliceStable(words, func(i, j int) bool {
+			return words[i].docCount() > words[j].docCount()
+		})
+	default:
+		return WordPage{}, invalidf("sort", "unsupported sort %q", sortBy)
+	}
+
+	start, end := pageRange(len(words), offset, limit)
+	page := WordPage{
+		Total: len(words),
+		Words: make([]WordInfo, 0, end-start),
+	}
+	for _, w := range words[start:end] {
+		page.Words = append(page.Words, t.wordInfo(w))
+	}
+	return page, nil
+}
+
+func pageRange(total, offset, limit int) (start, end int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	end = offset + limit
+	if limit < 0 || end > total {
+		end = total
+	}
+	return offset, end
+}
@@ -0,0 +1,53 @@
+package tfidf
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tfidf := NewTFIDF()
+	if _, err := tfidf.UpsertDocs(context.Background(), testDocs()); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, ok := tfidf.GetDoc("2")
+	if !ok || !reflect.DeepEqual(doc.Fields["title"], []string{"fruit"}) {
+		t.Fatalf("unexpected doc %+v", doc)
+	}
+	if _, ok := tfidf.GetDoc("missing"); ok {
+		t.Fatal("expected a missing doc not found")
+	}
+
+	info, ok := tfidf.LookupWord("banana")
+	if !ok || info.DF != 2 || info.Order != 1 || info.IDF != 0 || info.FieldDF != nil {
+		t.Fatalf("unexpected banana %+v", info)
+	}
+	info, _ = tfidf.LookupWord("fruit")
+	if !reflect.DeepEqual(info.FieldDF, map[string]int{"title": 1}) {
+		t.Fatalf("expected fruit counted in title, got %+v", info)
+	}
+	if info, ok := tfidf.WordByIndex(0); !ok || info.Word != "apple" {
+		t.Fatalf("expected apple at index 0, got %+v", info)
+	}
+	if _, ok := tfidf.WordByIndex(99); ok {
+		t.Fatal("expected index out of range not found")
+	}
+
+	docs, err := tfidf.ListDocs(1, 5, SortByLength)
+	if err != nil || docs.Total != 3 || len(docs.Docs) != 2 || docs.Docs[0].ID != "2" || docs.Docs[1].ID != "3" {
+		t.Fatalf("unexpected page %+v, %v", docs, err)
+	}
+	words, err := tfidf.ListWords(0, 2, SortByDFDesc)
+	if err != nil || words.Total != 5 || words.Words[0].Word != "banana" || words.Words[1].Word != "apple" {
+		t.Fatalf("unexpected page %+v, %v", words, err)
+	}
+	words, _ = tfidf.ListWords(3, -1, SortByWord)
+	if len(words.Words) != 2 || words.Words[0].Word != "durian" || words.Words[1].Word != "fruit" {
+		t.Fatalf("unexpected page %+v", words)
+	}
+	if _, err := tfidf.ListWords(0, 1, "bogus"); err == nil {
+		t.Fatal("expected an unsupported sort to be invalid")
+	}
+}
@@ -0,0 +1,425 @@
+package tfidf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultLSAOversample = 10
+	defaultLSAPowerIters = 2
+	defaultLSASeed       = 1
+
+	jacobiMaxSweeps = 100
+)
+
+var ErrLSANotFitted = errors.New("lsa model not fitted")
+
+type LSAOptions struct {
+	Components int `json:"components"`
+	Oversample int `json:"oversample,omitempty"`
+	// negative value disables power iterations
+	PowerIters int   `json:"power_iters,omitempty"`
+	Seed       int64 `json:"seed,omitempty"`
+}
+
+// LSAModel is the truncated SVD projection of the term-document matrix,
+// Vectors[i] holds the component values of the word indexed Terms[i].
+// Words[i] is that word when the model is fitted, a model whose words no
+// longer match the vocabulary is stale, e.g. after fsck repaired indexes.
+type LSAModel struct {
+	Components int         `json:"components"`
+	Singular   []float64   `json:"singular"`
+	Terms      []int       `json:"terms"`
+	Words      []string    `json:"words,omitempty"`
+	Vectors    [][]float64 `json:"vectors"`
+	DocCount   int         `json:"doc_count"`
+
+	rows map[int]int
+}
+
+type LSAEmbedding struct {
+	ID     string    `json:"id"`
+	Vector []float64 `json:"vector"`
+}
+
+func (m *LSAModel) index() {
+	m.rows = make(map[int]int, len(m.Terms))
+	for i, term := range m.Terms {
+		m.rows[term] = i
+	}
+}
+
+// project folds the doc vector into the latent space
+func (m *LSAModel) project(vec sparseVector) []float64 {
+	res := make([]float64, m.Components)
+	for index, x := range vec {
+		row, ok := m.rows[index]
+		if !ok {
+			continue
+		}
+		for c := range res {
+			res[c] += x * m.Vectors[row][c]
+		}
+	}
+	return res
+}
+
+type lsaHolder struct {
+	sync.Mutex
+	model   *LSAModel
+	updated bool
+}
+
+func (h *lsaHolder) get() *LSAModel {
+	defer h.Unlock()
+	h.Lock()
+	return h.model
+}
+
+func (h *lsaHolder) set(m *LSAModel) {
+	defer h.Unlock()
+	h.Lock()
+	h.model = m
+	h.updated = true
+}
+
+// FitLSA computes a randomized truncated SVD (Halko et al.) of the matrix of
+// normalized TF-IDF vectors of stored docs and replaces the current model.
+func (t *TFIDF) FitLSA(ctx context.Context, opts LSAOptions) (*LSAModel, error) {
+	if opts.Components < 1 {
+		return nil, invalidf("components", "components should be positive")
+	}
+	if opts.Oversample <= 0 {
+		opts.Oversample = defaultLSAOversample
+	}
+	if opts.PowerIters == 0 {
+		opts.PowerIters = defaultLSAPowerIters
+	}
+	if opts.Seed == 0 {
+		opts.Seed = defaultLSASeed
+	}
+
+	// columns of the term-document matrix, rows are compacted word indexes
+	docs := t.storedDocs()
+	rowOf := make(map[int]int)
+	terms := make([]int, 0)
+	columns := make([]map[int]float64, 0, len(docs))
+	for i := range docs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vec := t.docVector(docs[i])
+		if vec.norm() == 0 {
+			continue
+		}
+		vec.normalize()
+		col := make(map[int]float64, len(vec))
+		for index, x := range vec {
+			row, ok := rowOf[index]
+			if !ok {
+				row = len(terms)
+				rowOf[index] = row
+				terms = append(terms, index)
+			}
+			col[row] = x
+		}
+		columns = append(columns, col)
+	}
+	rank := len(terms)
+	if len(columns) < rank {
+		rank = len(columns)
+	}
+	if opts.Components > rank {
+		return nil, invalidf("components", "components %d exceed the rank bound %d of the corpus", opts.Components, rank)
+	}
+	l := opts.Components + opts.Oversample
+	if l > rank {
+		l = rank
+	}
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	omega := newMatrix(len(columns), l)
+	for i := range omega {
+		for j := range omega[i] {
+			omega[i][j] = rnd.NormFloat64()
+		}
+	}
+	q := orthonormalize(multiplyA(columns, len(terms), omega))
+	for i := 0; i < opts.PowerIters; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		z := orthonormalize(multiplyAT(columns, q))
+		q = orthonormalize(multiplyA(columns, len(terms), z))
+	}
+
+	// B = Qᵀ A is small, its left singular vectors come from eigenvectors of B Bᵀ
+	bt := multiplyAT(columns, q)
+	bbt := newMatrix(l, l)
+	for _, row := range bt {
+		for i := 0; i < l; i++ {
+			for j := i; j < l; j++ {
+				bbt[i][j] += row[i] * row[j]
+			}
+		}
+	}
+	for i := 0; i < l; i++ {
+		for j := 0; j < i; j++ {
+			bbt[i][j] = bbt[j][i]
+		}
+	}
+	values, vectors := jacobiEigen(bbt)
+
+	order := make([]int, l)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return values[order[i]] > values[order[j]]
+	})
+
+	model := &LSAModel{
+		Components: opts.Components,
+		Singular:   make([]float64, opts.Components),
+		Terms:      terms,
+		Words:      make([]string, len(terms)),
+		Vectors:    newMatrix(len(terms), opts.Components),
+		DocCount:   len(columns),
+	}
+	for i, index := range terms {
+		model.Words[i], _ = t.wordValue(index)
+	}
+	for c := 0; c < opts.Components; c++ {
+		k := order[c]
+		model.Singular[c] = math.Sqrt(math.Max(values[k], 0))
+		for row := range q {
+			sum := 0.0
+			for j := 0; j < l; j++ {
+				sum += q[row][j] * vectors[j][k]
+			}
+			model.Vectors[row][c] = sum
+		}
+	}
+	model.index()
+	t.lsa.set(model)
+	return model, nil
+}
+
+// Embed projects docs into the latent space without upserting them
+func (t *TFIDF) Embed(ctx context.Context, docs []Doc) ([]LSAEmbedding, error) {
+	model := t.lsa.get()
+	if model == nil {
+		return nil, ErrLSANotFitted
+	}
+	res := make([]LSAEmbedding, 0, len(docs))
+	docs = t.analyzeDocs(docs)
+	for i := range docs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res = append(res, LSAEmbedding{
+			ID:     docs[i].ID,
+			Vector: model.project(t.docVector(docs[i]).normalize()),
+		})
+	}
+	return res, nil
+}
+
+// EmbedStored projects stored docs into the latent space
+func (t *TFIDF) EmbedStored(ctx context.Context, ids []string) ([]LSAEmbedding, error) {
+	docs := make([]Doc, 0, len(ids))
+	for i, id := range ids {
+		doc, ok := t.GetDoc(id)
+		if !ok {
+			return nil, invalidf(fmt.Sprintf("ids[%d]", i), "doc %q not found", id)
+		}
+		docs = append(docs, doc)
+	}
+	return t.Embed(ctx, docs)
+}
+
+// ReadLSA reads a model saved by SaveLSA
+func ReadLSA(filename string) (*LSAModel, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	model := &LSAModel{}
+	err = json.Unmarshal(data, model)
+	if err != nil {
+		return nil, err
+	}
+	if len(model.Terms) != len(model.Vectors) || len(model.Singular) != model.Components ||
+		(len(model.Words) > 0 && len(model.Words) != len(model.Terms)) {
+		return nil, fmt.Errorf("malformed lsa model %s", filename)
+	}
+	model.index()
+	return model, nil
+}
+
+// LoadLSA loads the model unless it is stale, a stale model is logged and
+// left out until it is refitted
+func (t *TFIDF) LoadLSA(filename string) error {
+	model, err := ReadLSA(filename)
+	if err != nil {
+		return err
+	}
+	if issues := checkLSA(model, t.wordValue); len(issues) > 0 {
+		log.Printf("lsa model %s is not loaded, %s, refit it by POST /lsa/fit", filename, issues[0].Message)
+		return nil
+	}
+	t.lsa.Lock()
+	t.lsa.model = model
+	t.lsa.Unlock()
+	return nil
+}
+
+// SaveLSA writes the model once it has been refitted
+func (t *TFIDF) SaveLSA(filename string) error {
+	t.lsa.Lock()
+	model, updated := t.lsa.model, t.lsa.updated
+	t.lsa.updated = false
+	t.lsa.Unlock()
+	if model == nil || !updated {
+		return nil
+	}
+
+	data, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data, t.fileMode)
+}
+
+func newMatrix(rows, cols int) [][]float64 {
+	m := make([][]float64, rows)
+	for i := range m {
+		m[i] = make([]float64, cols)
+	}
+	return m
+}
+
+// multiplyA computes A × m, where A is given by sparse columns
+func multiplyA(columns []map[int]float64, rows int, m [][]float64) [][]float64 {
+	cols := len(m[0])
+	res := newMatrix(rows, cols)
+	for j, col := range columns {
+		for row, a := range col {
+			for c := 0; c < cols; c++ {
+				res[row][c] += a * m[j][c]
+			}
+		}
+	}
+	return res
+}
+
+// multiplyAT computes Aᵀ × m, where A is given by sparse columns
+func multiplyAT(columns []map[int]float64, m [][]float64) [][]float64 {
+	cols := len(m[0])
+	res := newMatrix(len(columns), cols)
+	for j, col := range columns {
+		for row, a := range col {
+			for c := 0; c < cols; c++ {
+				res[j][c] += a * m[row][c]
+			}
+		}
+	}
+	return res
+}
+
+// orthonormalize applies modified Gram-Schmidt to the columns in place,
+// columns depending on previous ones are zeroed.
+func orthonormalize(m [][]float64) [][]float64 {
+	if len(m) == 0 {
+		return m
+	}
+	cols := len(m[0])
+	for c := 0; c < cols; c++ {
+		for p := 0; p < c; p++ {
+			dot := 0.0
+			for i := range m {
+				dot += m[i][c] * m[i][p]
+			}
+			for i := range m {
+				m[i][c] -= dot * m[i][p]
+			}
+		}
+		norm := 0.0
+		for i := range m {
+			norm += m[i][c] * m[i][c]
+		}
+		norm = math.Sqrt(norm)
+		for i := range m {
+			if norm < 1e-12 {
+				m[i][c] = 0
+			} else {
+				m[i][c] /= norm
+			}
+		}
+	}
+	return m
+}
+
+// jacobiEigen decomposes the symmetric matrix with cyclic Jacobi rotations,
+// the k-th eigenvector is the k-th column of vectors.
+func jacobiEigen(a [][]float64) (values []float64, vectors [][]float64) {
+	n := len(a)
+	vectors = newMatrix(n, n)
+	for i := range vectors {
+		vectors[i][i] = 1
+	}
+	for sweep := 0; sweep < jacobiMaxSweeps; sweep++ {
+		off := 0.0
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off < 1e-22 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if math.Abs(a[p][q]) < 1e-300 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				tan := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					tan = -tan
+				}
+				cos := 1 / math.Sqrt(tan*tan+1)
+				sin := tan * cos
+				for k := 0; k < n; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = cos*akp - sin*akq
+					a[k][q] = sin*akp + cos*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = cos*apk - sin*aqk
+					a[q][k] = sin*apk + cos*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := vectors[k][p], vectors[k][q]
+					vectors[k][p] = cos*vkp - sin*vkq
+					vectors[k][q] = sin*vkp + cos*vkq
+				}
+			}
+		}
+	}
+	values = make([]float64, n)
+	for i := range values {
+		values[i] = a[i][i]
+	}
+	return values, vectors
+}
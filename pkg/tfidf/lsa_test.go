@@ -0,0 +1,78 @@
+package tfidf
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFitLSA(t *testing.T) {
+	ctx := context.Background()
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(ctx, []Doc{
+		{ID: "1", Words: []string{"apple", "banana", "cherry"}},
+		{ID: "2", Words: []string{"apple", "banana", "durian"}},
+		{ID: "3", Words: []string{"egg", "fig"}},
+		{ID: "4", Words: []string{"grape", "honey"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tfidf.Embed(ctx, []Doc{{Words: []string{"apple"}}}); err != ErrLSANotFitted {
+		t.Fatalf("expected %v, got %v", ErrLSANotFitted, err)
+	}
+
+	// columns are unit vectors, AᵀA is the identity but for the cosine c of docs
+	// 1 and 2, so singular values are √(1+c), 1, 1 and √(1-c)
+	d1, _ := tfidf.GetDoc("1")
+	d2, _ := tfidf.GetDoc("2")
+	c := tfidf.docVector(d1).normalize().dot(tfidf.docVector(d2).normalize())
+	if c <= 0 || c >= 1 {
+		t.Fatalf("unexpected cosine %v", c)
+	}
+	model, err := tfidf.FitLSA(ctx, LSAOptions{Components: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float64{math.Sqrt(1 + c), 1, 1, math.Sqrt(1 - c)}
+	for i := range expected {
+		if math.Abs(model.Singular[i]-expected[i]) > 1e-9 {
+			t.Fatalf("expected singular values %v, got %v", expected, model.Singular)
+		}
+	}
+	if _, err := tfidf.FitLSA(ctx, LSAOptions{Components: 5}); err == nil {
+		t.Fatal("expected components above the rank to be invalid")
+	}
+
+	// folding in keeps norms and angles of vectors spanned by the model
+	embeddings, err := tfidf.Embed(ctx, []Doc{d1, d2, {ID: "q", Words: []string{"egg", "fig", "unknown"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := func(a, b []float64) float64 {
+		sum := 0.0
+		for i := range a {
+			sum += a[i] * b[i]
+		}
+		return sum
+	}
+	e1, e2, q := embeddings[0].Vector, embeddings[1].Vector, embeddings[2].Vector
+	if math.Abs(dot(e1, e1)-1) > 1e-9 || math.Abs(dot(e1, e2)-c) > 1e-9 || math.Abs(dot(e1, q)) > 1e-9 {
+		t.Fatalf("unexpected embeddings %v", embeddings)
+	}
+	// the unknown word is left out before normalizing
+	if n := dot(q, q); math.Abs(n-1) > 1e-9 {
+		t.Fatalf("expected the query projected as a unit vector, got norm² %v", n)
+	}
+
+	filename := filepath.Join(t.TempDir(), "lsa.json")
+	if err := tfidf.SaveLSA(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadLSA(filename)
+	if err != nil || !reflect.DeepEqual(loaded.Singular, model.Singular) || !reflect.DeepEqual(loaded.Words, model.Words) {
+		t.Fatalf("expected the model read back, got %+v, %v", loaded, err)
+	}
+}
@@ -0,0 +1,67 @@
+package tfidf
+
+import "strings"
+
+// ngramSeparator joins the tokens of a n-gram into a single vocabulary term
+const ngramSeparator = " "
+
+type term struct {
+	value string
+	order int
+	field string
+}
+
+// WithNGramRange works like `ngram_range` of sklearn, every n-gram whose order
+// falls into [min, max] is generated from `Doc.Words` and indexed as a term.
+// The default range is (1, 1), unigrams only.
+func WithNGramRange(min, max int) Option {
+	return func(t *TFIDF) {
+		t.ngramMin = min
+		t.ngramMax = max
+	}
+}
+
+func ngrams(words []string, field string, min, max int) []term {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	res := make([]term, 0, len(words)*(max-min+1))
+	for n := min; n <= max; n++ {
+		for i := 0; i+n <= len(words); i++ {
+			res = append(res, term{
+				value: strings.Join(words[i:i+n], ngramSeparator),
+				order: n,
+				field: field,
+			})
+		}
+	}
+	return res
+}
+
+// terms generates the vocabulary terms of all fields of the document,
+// synonyms follow the n-grams of their field as terms of their own
+func (t *TFIDF) terms(doc Doc) []term {
+	var res []term
+	for _, field := range doc.fieldNames() {
+		res = append(res, ngrams(doc.fieldWords(field), field, t.ngramMin, t.ngramMax)...)
+		for _, syn := range doc.Synonyms[field] {
+			res = append(res, term{
+				value: syn,
+				order: strings.Count(syn, ngramSeparator) + 1,
+				field: field,
+			})
+		}
+	}
+	return res
+}
+
+func termValues(terms []term) []string {
+	res := make([]string, len(terms))
+	for i := range terms {
+		res[i] = terms[i].value
+	}
+	return res
+}
@@ -0,0 +1,415 @@
+package tfidf
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const openAPIVersion = "3.0.3"
+
+type route struct {
+	method  string
+	path    string
+	handler gin.HandlerFunc
+	summary string
+	params  []param
+	// zero values of request and response bodies, nil means no body,
+	// several responses are described by oneOf
+	request   interface{}
+	responses []interface{}
+	// streaming responses are not bounded by the default timeout
+	streaming bool
+}
+
+type param struct {
+	name        string
+	in          string
+	typ         string
+	description string
+}
+
+var pageParams = []param{
+	{name: "offset", in: "query", typ: "integer", description: "number of items skipped"},
+	{name: "limit", in: "query", typ: "integer", description: "page size, 1 to 1000, 20 by default"},
+}
+
+func (s *Server) routes() []route {
+	return []route{
+		{
+			method: http.MethodPost, path: "/upsert_docs", handler: s.UpsertDocs,
+			summary: "Upsert docs, docs sharing an id are saved by last write wins",
+			params: []param{
+				{name: "on_duplicate", in: "query", typ: "string", description: "reject near-duplicate docs or replace the docs they duplicate"},
+				{name: "threshold", in: "query", typ: "number", description: "SimHash similarity of near-duplicates, 0.9 by default"},
+			},
+			request:   []Doc{},
+			responses: []interface{}{"ok", UpsertResult{}},
+		},
+		{
+			method: http.MethodPost, path: "/get_doc_vector", handler: s.GetDocVector,
+			summary: "Upsert the doc and return its TF-IDF vector",
+			params: []param{
+				{name: "explain", in: "query", typ: "boolean", description: "explain how every value is computed"},
+			},
+			request:   Doc{},
+			responses: []interface{}{[]WordTFIDF{}},
+		},
+		{
+			method: http.MethodGet, path: "/statistics", handler: s.GetStatistics,
+			summary:   "Count docs and words",
+			responses: []interface{}{Statistics{}},
+		},
+		{
+			method: http.MethodGet, path: "/analytics", handler: s.GetAnalytics,
+			summary: "Report document frequencies, doc lengths and vocabulary growth",
+			params: []param{
+				{name: "top", in: "query", typ: "integer", description: "number of top and bottom words by df, 20 by default"},
+				{name: "buckets", in: "query", typ: "integer", description: "buckets of the doc length histogram, 10 by default"},
+			},
+			responses: []interface{}{Analytics{}},
+		},
+		{
+			method: http.MethodGet, path: "/docs", handler: s.ListDocs,
+			summary: "List stored docs",
+			params: append([]param{
+				{name: "sort", in: "query", typ: "string", description: "index, id or length"},
+			}, pageParams...),
+			responses: []interface{}{DocPage{}},
+		},
+		{
+			method: http.MethodGet, path: "/docs/:id", handler: s.GetDoc,
+			summary:   "Get a stored doc",
+			params:    []param{{name: "id", in: "path", typ: "string"}},
+			responses: []interface{}{Doc{}},
+		},
+		{
+			method: http.MethodGet, path: "/docs/:id/vector", handler: s.GetStoredDocVector,
+			summary: "Return the TF-IDF vector of a stored doc without upserting it",
+			params: []param{
+				{name: "id", in: "path", typ: "string"},
+				{name: "explain", in: "query", typ: "boolean", description: "explain how every value is computed"},
+			},
+			responses: []interface{}{[]WordTFIDF{}},
+		},
+		{
+			method: http.MethodGet, path: "/docs/:id/related", handler: s.GetRelated,
+			summary: "List precomputed related docs by cosine similarity, docs not refreshed yet are computed on demand",
+			params: []param{
+				{name: "id", in: "path", typ: "string"},
+				{name: "limit", in: "query", typ: "integer", description: "number of related docs, all precomputed ones by default"},
+			},
+			responses: []interface{}{RelatedDocs{}},
+		},
+		{
+			method: http.MethodDelete, path: "/docs/:id", handler: s.DeleteDoc,
+			summary:   "Delete a stored doc, its words stay in the vocabulary",
+			params:    []param{{name: "id", in: "path", typ: "string"}},
+			responses: []interface{}{"ok"},
+		},
+		{
+			method: http.MethodGet, path: "/words", handler: s.ListWords,
+			summary: "List the vocabulary",
+			params: append([]param{
+				{name: "sort", in: "query", typ: "string", description: "index, word, df or -df"},
+			}, pageParams...),
+			responses: []interface{}{WordPage{}},
+		},
+		{
+			method: http.MethodGet, path: "/words/:word", handler: s.GetWord,
+			summary:   "Look up a word",
+			params:    []param{{name: "word", in: "path", typ: "string"}},
+			responses: []interface{}{WordInfo{}},
+		},
+		{
+			method: http.MethodGet, path: "/words/by-index/:i", handler: s.GetWordByIndex,
+			summary:   "Look up a word by its index in vectors",
+			params:    []param{{name: "i", in: "path", typ: "integer"}},
+			responses: []interface{}{WordInfo{}},
+		},
+		{
+			method: http.MethodPost, path: "/cluster", handler: s.Cluster,
+			summary:   "Cluster stored docs by spherical k-means",
+			request:   ClusterOptions{},
+			responses: []interface{}{ClusterResult{}},
+		},
+		{
+			method: http.MethodGet, path: "/duplicates", handler: s.GetDuplicates,
+			summary: "List clusters of near-duplicate docs",
+			params: []param{
+				{name: "threshold", in: "query", typ: "number", description: "SimHash similarity, 0.9 by default"},
+			},
+			responses: []interface{}{[]DuplicateCluster{}},
+		},
+		{
+			method: http.MethodGet, path: "/query", handler: s.Query,
+			summary: "Search docs by a boolean query ranked by TF-IDF",
+			params: append([]param{
+				{name: "q", in: "query", typ: "string", description: `terms, "phrases", field:term, fuzzy~N, AND, OR, NOT and parentheses`},
+				{name: "explain", in: "query", typ: "boolean", description: "explain the score of every hit"},
+			}, pageParams...),
+			responses: []interface{}{QueryResult{}},
+		},
+		{
+			method: http.MethodGet, path: "/suggest", handler: s.Suggest,
+			summary: "Suggest spelling corrections ranked by document frequency",
+			params: []param{
+				{name: "word", in: "query", typ: "string"},
+				{name: "max_distance", in: "query", typ: "integer", description: "max edit distance, 2 by default"},
+				{name: "limit", in: "query", typ: "integer", description: "10 by default"},
+			},
+			responses: []interface{}{[]Suggestion{}},
+		},
+		{
+			method: http.MethodPost, path: "/search", handler: s.Search,
+			summary:   "Rank stored docs by cosine similarity to an ad-hoc doc",
+			request:   SearchRequest{},
+			responses: []interface{}{[]SearchHit{}},
+		},
+		{
+			method: http.MethodPost, path: "/ann/rebuild", handler: s.RebuildANN,
+			summary:   "Rehash every doc of the LSH index used by approximate search with the current IDF",
+			responses: []interface{}{ANNStats{}},
+		},
+		{
+			method: http.MethodPost, path: "/lsa/fit", handler: s.FitLSA,
+			summary:   "Fit the LSA model by truncated SVD",
+			request:   LSAOptions{},
+			responses: []interface{}{LSAFitResult{}},
+		},
+		{
+			method: http.MethodPost, path: "/lsa/embed", handler: s.EmbedLSA,
+			summary:   "Project stored or ad-hoc docs into the LSA space",
+			request:   EmbedRequest{},
+			responses: []interface{}{[]LSAEmbedding{}},
+		},
+		{
+			method: http.MethodPost, path: "/train", handler: s.Train,
+			summary:   "Train the classifier on stored docs carrying labels, cross validated by folds",
+			request:   TrainOptions{},
+			responses: []interface{}{TrainResult{}},
+		},
+		{
+			method: http.MethodPost, path: "/classify", handler: s.Classify,
+			summary:   "Predict label probabilities of docs without upserting them",
+			request:   []Doc{},
+			responses: []interface{}{[]Classification{}},
+		},
+		{
+			method: http.MethodGet, path: "/changes", handler: s.GetChanges,
+			summary: "List changes after a sequence number, or stream them as server-sent events when accepting text/event-stream",
+			params: []param{
+				{name: "since", in: "query", typ: "integer", description: "sequence number of the last change seen, the Last-Event-ID header takes precedence"},
+			},
+			responses: []interface{}{ChangePage{}},
+			streaming: true,
+		},
+		{
+			method: http.MethodPost, path: "/admin/synonyms/reload", handler: s.ReloadSynonyms,
+			summary:   "Read the synonym dictionary file again",
+			responses: []interface{}{SynonymStats{}},
+		},
+		{
+			method: http.MethodGet, path: "/admin/backup", handler: s.GetBackup,
+			summary:   "Stream a point-in-time backup of docs, words and the LSA model",
+			responses: []interface{}{Backup{}},
+			streaming: true,
+		},
+		{
+			method: http.MethodPost, path: "/admin/restore", handler: s.PostRestore,
+			summary:   "Load a backup and swap it in for the served corpus",
+			request:   Backup{},
+			responses: []interface{}{Statistics{}},
+		},
+	}
+}
+
+var pathParamRegexp = regexp.MustCompile(`:([^/]+)`)
+
+// OpenAPI generates the document from routes and Go types of their bodies
+func (s *Server) OpenAPI() map[string]interface{} {
+	g := schemaGenerator{components: make(map[string]interface{})}
+	errorRef := g.schema(reflect.TypeOf(ErrorBody{}))
+
+	paths := make(map[string]interface{})
+	for _, r := range s.routes() {
+		path := pathParamRegexp.ReplaceAllString(r.path, "{$1}")
+		op := map[string]interface{}{
+			"summary":     r.summary,
+			"operationId": strings.ToLower(r.method) + strings.NewReplacer("/", "_", ":", "", "-", "_").Replace(r.path),
+		}
+
+		params := make([]interface{}, 0, len(r.params))
+		for _, p := range r.params {
+			params = append(params, map[string]interface{}{
+				"name":        p.name,
+				"in":          p.in,
+				"required":    p.in == "path",
+				"description": p.description,
+				"schema":      map[string]interface{}{"type": p.typ},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if r.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": g.schema(reflect.TypeOf(r.request)),
+					},
+				},
+			}
+		}
+
+		var okSchema interface{}
+		if len(r.responses) == 1 {
+			okSchema = g.schema(reflect.TypeOf(r.responses[0]))
+		} else {
+			oneOf := make([]interface{}, 0, len(r.responses))
+			for _, resp := range r.responses {
+				oneOf = append(oneOf, g.schema(reflect.TypeOf(resp)))
+			}
+			okSchema = map[string]interface{}{"oneOf": oneOf}
+		}
+		op["responses"] = map[string]interface{}{
+			"200": jsonResponse("OK", okSchema),
+			"4XX": jsonResponse("Invalid request", errorRef),
+			"503": jsonResponse("Timed out", errorRef),
+		}
+
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(r.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "tfidf-server",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+		},
+	}
+}
+
+func jsonResponse(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schema,
+			},
+		},
+	}
+}
+
+// schemaGenerator describes Go types by JSON schema, named structs are
+// collected into components and referenced.
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": g.schema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.schema(t.Elem()),
+		}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.components[t.Name()]; !ok {
+			// placeholder breaks cycles of recursive types
+			g.components[t.Name()] = nil
+			g.components[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			// fields of embedded structs are promoted like encoding/json does
+			embedded := g.object(f.Type)
+			for k, v := range embedded["properties"].(map[string]interface{}) {
+				properties[k] = v
+			}
+			if r, ok := embedded["required"].([]string); ok {
+				required = append(required, r...)
+			}
+			continue
+		}
+		name, opts := f.Name, ""
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) > 1 {
+				opts = parts[1]
+			}
+		}
+		properties[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	res := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		res["required"] = required
+	}
+	return res
+}
@@ -0,0 +1,601 @@
+package tfidf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type queryOp int
+
+const (
+	opTerm queryOp = iota
+	opAnd
+	opOr
+	opNot
+)
+
+// queryNode is a node of the parsed query, terms of several tokens are phrases
+type queryNode struct {
+	op       queryOp
+	field    string
+	tokens   []string
+	children []*queryNode
+	// max edit distance of a fuzzy term, 0 matches exactly
+	fuzzy int
+	// the term a fuzzy or synonym term was expanded from
+	expandedFrom string
+}
+
+type QueryHit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+	// contribution of every matched positive term to Score
+	Explanation []QueryTermExplanation `json:"explanation,omitempty"`
+}
+
+type QueryTermExplanation struct {
+	Term  string `json:"term"`
+	Field string `json:"field,omitempty"`
+	// occurrences weighted by field boosts
+	Count     float64 `json:"count"`
+	DocLength int     `json:"doc_length"`
+	TF        float64 `json:"tf"`
+	IDF       float64 `json:"idf"`
+	Score     float64 `json:"score"`
+	Formula   string  `json:"formula"`
+	// the query term a fuzzy match or synonym was expanded from
+	ExpandedFrom string `json:"expanded_from,omitempty"`
+}
+
+type QueryResult struct {
+	Total int        `json:"total"`
+	Hits  []QueryHit `json:"hits"`
+}
+
+type queryTokenKind int
+
+const (
+	tokWord queryTokenKind = iota
+	tokPhrase
+	tokLParen
+	tokRParen
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	field string
+	text  string
+	pos   int
+}
+
+func lexQuery(q string) ([]queryToken, error) {
+	runes := []rune(q)
+	res := make([]queryToken, 0)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			res = append(res, queryToken{kind: tokLParen, pos: i})
+			i++
+		case r == ')':
+			res = append(res, queryToken{kind: tokRParen, pos: i})
+			i++
+		default:
+			start := i
+			field := ""
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+				if runes[i] == ':' && field == "" && i > start {
+					field = string(runes[start:i])
+					start = i + 1
+				}
+				i++
+			}
+			if i < len(runes) && runes[i] == '"' && i == start {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end >= len(runes) {
+					return nil, invalidf("q", "unterminated phrase at %d", i)
+				}
+				res = append(res, queryToken{kind: tokPhrase, field: field, text: string(runes[i+1 : end]), pos: i})
+				i = end + 1
+				continue
+			}
+			if i == start {
+				return nil, invalidf("q", "missing term after %s: at %d", field, start)
+			}
+			res = append(res, queryToken{kind: tokWord, field: field, text: string(runes[start:i]), pos: start})
+		}
+	}
+	return res, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	i      int
+}
+
+// parseQuery parses AND, OR, NOT, parentheses, "quoted phrases", field:prefixes
+// and fuzzy~ terms, adjacent clauses without operator are joined by AND.
+func parseQuery(q string) (*queryNode, error) {
+	tokens, err := lexQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, invalidf("q", "query is empty")
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.tokens) {
+		return nil, invalidf("q", "unexpected %s at %d", p.describe(p.tokens[p.i]), p.tokens[p.i].pos)
+	}
+	return node, nil
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.i >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.i], true
+}
+
+func (p *queryParser) keyword(kw string) bool {
+	tok, ok := p.peek()
+	return ok && tok.kind == tokWord && tok.field == "" && tok.text == kw
+}
+
+func (p *queryParser) describe(tok queryToken) string {
+	switch tok.kind {
+	case tokLParen:
+		return "("
+	case tokRParen:
+		return ")"
+	default:
+		return fmt.Sprintf("%q", tok.text)
+	}
+}
+
+func (p *queryParser) parseOr() (*queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	node := &queryNode{op: opOr, children: []*queryNode{left}}
+	for p.keyword("OR") {
+		p.i++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, right)
+	}
+	if len(node.children) == 1 {
+		return left, nil
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseAnd() (*queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	node := &queryNode{op: opAnd, children: []*queryNode{left}}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokRParen || p.keyword("OR") {
+			break
+		}
+		if p.keyword("AND") {
+			p.i++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, right)
+	}
+	if len(node.children) == 1 {
+		return left, nil
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseNot() (*queryNode, error) {
+	if p.keyword("NOT") {
+		p.i++
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{op: opNot, children: []*queryNode{child}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (*queryNode, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, invalidf("q", "unexpected end of query")
+	}
+	switch tok.kind {
+	case tokLParen:
+		p.i++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		end, ok := p.peek()
+		if !ok || end.kind != tokRParen {
+			return nil, invalidf("q", "missing ) for ( at %d", tok.pos)
+		}
+		p.i++
+		return node, nil
+	case tokPhrase:
+		p.i++
+		tokens := strings.Fields(tok.text)
+		if len(tokens) == 0 {
+			return nil, invalidf("q", "empty phrase at %d", tok.pos)
+		}
+		return &queryNode{op: opTerm, field: tok.field, tokens: tokens}, nil
+	case tokWord:
+		if tok.field == "" && (tok.text == "AND" || tok.text == "OR") {
+			return nil, invalidf("q", "unexpected %s at %d", tok.text, tok.pos)
+		}
+		p.i++
+		text, fuzzy, err := parseFuzzy(tok)
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{op: opTerm, field: tok.field, tokens: []string{text}, fuzzy: fuzzy}, nil
+	default:
+		return nil, invalidf("q", "unexpected %s at %d", p.describe(tok), tok.pos)
+	}
+}
+
+// parseFuzzy splits term~ and term~N, where N defaults to 2
+func parseFuzzy(tok queryToken) (string, int, error) {
+	i := strings.LastIndex(tok.text, "~")
+	if i <= 0 {
+		return tok.text, 0, nil
+	}
+	if i == len(tok.text)-1 {
+		return tok.text[:i], defaultFuzzyDistance, nil
+	}
+	d, err := strconv.Atoi(tok.text[i+1:])
+	if err != nil {
+		return tok.text, 0, nil
+	}
+	if d < 0 || d > MaxFuzzyDistance {
+		return "", 0, invalidf("q", "edit distance of %s at %d should be 0 to %d", tok.text, tok.pos, MaxFuzzyDistance)
+	}
+	return tok.text[:i], d, nil
+}
+
+// expandQuery replaces fuzzy terms by OR of known words within the distance,
+// and terms by OR of the term and its synonyms at query time
+func (t *TFIDF) expandQuery(n *queryNode) *queryNode {
+	if n.op == opTerm {
+		value := strings.Join(n.tokens, ngramSeparator)
+		res := &queryNode{op: opOr}
+		if n.fuzzy > 0 {
+			for _, w := range t.expandFuzzy(n.tokens[0], n.fuzzy) {
+				child := &queryNode{op: opTerm, field: n.field, tokens: []string{w}}
+				if w != value {
+					child.expandedFrom = value
+				}
+				res.children = append(res.children, child)
+			}
+			return res
+		}
+		if t.synonymMode != SynonymsAtQuery {
+			return n
+		}
+		synonyms := t.synonyms.Expand(value)
+		if len(synonyms) == 0 {
+			return n
+		}
+		res.children = append(res.children, n)
+		for _, syn := range synonyms {
+			res.children = append(res.children, &queryNode{op: opTerm, field: n.field, tokens: strings.Split(syn, ngramSeparator), expandedFrom: value})
+		}
+		return res
+	}
+	for i := range n.children {
+		n.children[i] = t.expandQuery(n.children[i])
+	}
+	return n
+}
+
+// queryContext evaluates a query against a consistent copy of stored docs
+type queryContext struct {
+	t    *TFIDF
+	docs map[string]Doc
+	all  set
+}
+
+func (c *queryContext) eval(n *queryNode) set {
+	switch n.op {
+	case opAnd:
+		var res set
+		// negations are subtracted after positive clauses intersected
+		var negs []*queryNode
+		for _, child := range n.children {
+			if child.op == opNot {
+				negs = append(negs, child.children[0])
+				continue
+			}
+			s := c.eval(child)
+			if res == nil {
+				res = s
+				continue
+			}
+			res = intersect(res, s)
+		}
+		if res == nil {
+			res = copySet(c.all)
+		}
+		for _, neg := range negs {
+			for id := range c.eval(neg) {
+				res.del(id)
+			}
+		}
+		return res
+	case opOr:
+		res := make(set)
+		for _, child := range n.children {
+			for id := range c.eval(child) {
+				res.set(id)
+			}
+		}
+		return res
+	case opNot:
+		res := copySet(c.all)
+		for id := range c.eval(n.children[0]) {
+			res.del(id)
+		}
+		return res
+	default:
+		return c.match(n)
+	}
+}
+
+// candidates are docs containing every token or the term of an index-time
+// synonym of several tokens, verified later by positions
+func (c *queryContext) candidates(n *queryNode) set {
+	res := c.tokenCandidates(n)
+	if len(n.tokens) < 2 {
+		return res
+	}
+	w := c.t.wm.getWord(strings.Join(n.tokens, ngramSeparator))
+	if w == nil {
+		return res
+	}
+	ds := w.docSet
+	if n.field != "" {
+		ds = w.fieldDocs.get(n.field)
+	}
+	if ds != nil {
+		ds.Lock()
+		for id := range ds.m {
+			res.set(id)
+		}
+		ds.Unlock()
+	}
+	return res
+}
+
+func (c *queryContext) tokenCandidates(n *queryNode) set {
+	var res set
+	for _, token := range n.tokens {
+		w := c.t.wm.getWord(token)
+		if w == nil {
+			if c.t.ngramMin > 1 {
+				// unigrams are not indexed, every doc may match
+				return copySet(c.all)
+			}
+			return make(set)
+		}
+		var ds *docSet
+		if n.field == "" {
+			ds = w.docSet
+		} else {
+			ds = w.fieldDocs.get(n.field)
+		}
+		if ds == nil {
+			return make(set)
+		}
+		ds.Lock()
+		s := copySet(ds.m)
+		ds.Unlock()
+		if res == nil {
+			res = s
+			continue
+		}
+		res = intersect(res, s)
+	}
+	return res
+}
+
+// match verifies candidates by scanning their words since positions are not
+// indexed, a phrase costs O(total words of docs containing all its tokens)
+func (c *queryContext) match(n *queryNode) set {
+	res := make(set)
+	for id := range c.candidates(n) {
+		doc, ok := c.docs[id]
+		if ok && c.occurrences(doc, n) > 0 {
+			res.set(id)
+		}
+	}
+	return res
+}
+
+// occurrences counts the term in fields of the doc, weighted by field boosts
+func (c *queryContext) occurrences(doc Doc, n *queryNode) float64 {
+	value := strings.Join(n.tokens, ngramSeparator)
+	count := 0.0
+	for _, field := range doc.fieldNames() {
+		if n.field != "" && field != n.field {
+			continue
+		}
+		words := doc.fieldWords(field)
+		for i := 0; i+len(n.tokens) <= len(words); i++ {
+			matched := true
+			for j := range n.tokens {
+				if words[i+j] != n.tokens[j] {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				count += c.t.fieldBoost(field)
+			}
+		}
+		for _, syn := range doc.Synonyms[field] {
+			if syn == value {
+				count += c.t.fieldBoost(field)
+			}
+		}
+	}
+	return count
+}
+
+// score sums TF-IDF of positive terms
+func (c *queryContext) score(doc Doc, n *queryNode) float64 {
+	sum := 0.0
+	for _, e := range c.explain(doc, n) {
+		sum += e.Score
+	}
+	return sum
+}
+
+// explain scores every matched positive term, phrases out of the vocabulary
+// take the mean IDF of their tokens
+func (c *queryContext) explain(doc Doc, n *queryNode) []QueryTermExplanation {
+	switch n.op {
+	case opNot:
+		return nil
+	case opAnd, opOr:
+		var res []QueryTermExplanation
+		for _, child := range n.children {
+			res = append(res, c.explain(doc, child)...)
+		}
+		return res
+	}
+	count := c.occurrences(doc, n)
+	if count == 0 {
+		return nil
+	}
+	length := len(c.t.terms(doc))
+	value := strings.Join(n.tokens, ngramSeparator)
+	idf := 0.0
+	if c.t.wm.getWord(value) != nil {
+		idf = c.t.queryIDF(n.field, value)
+	} else {
+		for _, token := range n.tokens {
+			idf += c.t.queryIDF(n.field, token)
+		}
+		idf /= float64(len(n.tokens))
+	}
+	tf := count / float64(length)
+	return []QueryTermExplanation{{
+		Term:         value,
+		Field:        n.field,
+		Count:        count,
+		DocLength:    length,
+		TF:           tf,
+		IDF:          idf,
+		Score:        tf * idf,
+		Formula:      queryTermFormula,
+		ExpandedFrom: n.expandedFrom,
+	}}
+}
+
+func (t *TFIDF) queryIDF(field, value string) float64 {
+	if field != "" && t.perFieldIDF {
+		return t.FieldIDF(field, value)
+	}
+	return t.IDF(value)
+}
+
+// Query finds docs matching the boolean query, ranked by TF-IDF
+func (t *TFIDF) Query(ctx context.Context, q string, offset, limit int, explain bool) (*QueryResult, error) {
+	node, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	node = t.expandQuery(node)
+
+	docs := t.storedDocs()
+	c := &queryContext{
+		t:    t,
+		docs: make(map[string]Doc, len(docs)),
+		all:  make(set, len(docs)),
+	}
+	for i := range docs {
+		c.docs[docs[i].ID] = docs[i]
+		c.all.set(docs[i].ID)
+	}
+
+	matched := c.eval(node)
+	hits := make([]QueryHit, 0, len(matched))
+	for id := range matched {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hits = append(hits, QueryHit{
+			ID:    id,
+			Score: c.score(c.docs[id], node),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score == hits[j].Score {
+			return hits[i].ID < hits[j].ID
+		}
+		return hits[i].Score > hits[j].Score
+	})
+
+	start, end := pageRange(len(hits), offset, limit)
+	hits = hits[start:end]
+	if explain {
+		for i := range hits {
+			hits[i].Explanation = c.explain(c.docs[hits[i].ID], node)
+		}
+	}
+	return &QueryResult{
+		Total: len(matched),
+		Hits:  hits,
+	}, nil
+}
+
+func intersect(a, b set) set {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	res := make(set, len(a))
+	for k := range a {
+		if b.exist(k) {
+			res.set(k)
+		}
+	}
+	return res
+}
+
+func copySet(s set) set {
+	res := make(set, len(s))
+	for k := range s {
+		res.set(k)
+	}
+	return res
+}
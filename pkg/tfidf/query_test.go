@@ -0,0 +1,117 @@
+package tfidf
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func queryTestTFIDF(t *testing.T) *TFIDF {
+	t.Helper()
+	tfidf := NewTFIDF()
+	_, err := tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "1", Words: []string{"red", "apple", "pie"}},
+		{ID: "2", Words: []string{"apple", "red", "wine"}},
+		{ID: "3", Words: []string{"green", "apple"}, Fields: map[string][]string{"title": {"pie"}}},
+		{ID: "4", Words: []string{"banana", "bread"}},
+		{ID: "5", Words: []string{"cherry"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tfidf
+}
+
+func TestQuery(t *testing.T) {
+	tfidf := queryTestTFIDF(t)
+	cases := []struct {
+		q   string
+		ids []string
+	}{
+		{q: "apple", ids: []string{"1", "2", "3"}},
+		// AND binds tighter than OR, adjacent terms are joined by AND
+		{q: "banana OR red wine", ids: []string{"2", "4"}},
+		{q: "banana OR red AND wine", ids: []string{"2", "4"}},
+		{q: "(banana OR red) wine", ids: []string{"2"}},
+		{q: "apple NOT red", ids: []string{"3"}},
+		{q: "NOT apple", ids: []string{"4", "5"}},
+		{q: "NOT NOT cherry", ids: []string{"5"}},
+		{q: "apple AND NOT (pie OR wine)", ids: []string{}},
+		{q: `"red apple"`, ids: []string{"1"}},
+		{q: `"apple red"`, ids: []string{"2"}},
+		{q: `"apple pie"`, ids: []string{"1"}},
+		{q: "title:pie", ids: []string{"3"}},
+		{q: "pie", ids: []string{"1", "3"}},
+		{q: "aple~1", ids: []string{"1", "2", "3"}},
+		{q: "durian", ids: []string{}},
+	}
+	for _, c := range cases {
+		res, err := tfidf.Query(context.Background(), c.q, 0, 10, false)
+		if err != nil {
+			t.Fatalf("%s: %v", c.q, err)
+		}
+		ids := make([]string, 0, len(res.Hits))
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.ID)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, c.ids) || res.Total != len(c.ids) {
+			t.Errorf("%s: expected %v, got %v of %d", c.q, c.ids, ids, res.Total)
+		}
+	}
+}
+
+func TestQueryParseErrors(t *testing.T) {
+	tfidf := queryTestTFIDF(t)
+	for _, q := range []string{
+		"",
+		"   ",
+		`"red apple`,
+		`""`,
+		"(apple",
+		"apple)",
+		"apple OR",
+		"AND apple",
+		"NOT",
+		"title:",
+		"apple~9",
+	} {
+		_, err := tfidf.Query(context.Background(), q, 0, 10, false)
+		if err == nil {
+			t.Errorf("%q: expected a parse error", q)
+			continue
+		}
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("%q: expected a validation error, got %T %v", q, err, err)
+		}
+	}
+}
+
+func TestQueryExplain(t *testing.T) {
+	tfidf := queryTestTFIDF(t)
+	res, err := tfidf.Query(context.Background(), "aple~1 NOT wine", 0, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 2 {
+		t.Fatalf("expected docs 1 and 3, got %+v", res.Hits)
+	}
+	for _, hit := range res.Hits {
+		if len(hit.Explanation) != 1 {
+			t.Fatalf("expected apple explained in doc %s, got %+v", hit.ID, hit.Explanation)
+		}
+		e := hit.Explanation[0]
+		if e.Term != "apple" || e.ExpandedFrom != "aple" || e.Count != 1 {
+			t.Fatalf("unexpected explanation %+v", e)
+		}
+		if math.Abs(e.TF*e.IDF-hit.Score) > 1e-9 || math.Abs(e.IDF-tfidf.IDF("apple")) > 1e-9 {
+			t.Fatalf("explanation %+v does not add up to score %v", e, hit.Score)
+		}
+	}
+	// the title of doc 3 counts in its length, equal scores rank by id
+	if res.Hits[0].ID != "1" || res.Hits[0].Score != res.Hits[1].Score {
+		t.Fatalf("expected docs 1 and 3 tied, got %+v", res.Hits)
+	}
+}
@@ -0,0 +1,508 @@
+package tfidf
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRelatedK          = 10
+	defaultRelatedMaxDFRatio = 0.5
+	defaultRelatedMaxTerms   = 32
+
+	// vectors cached by a refresh, the cache is dropped once full
+	maxRelatedCache = 4096
+)
+
+// RelatedOptions tune the precomputed related docs. Candidates are docs
+// sharing one of the MaxTerms heaviest words of a doc, words found in more
+// than MaxDFRatio of docs are skipped since their posting lists are long and
+// their weights small, candidates are then ranked by exact cosine similarity.
+type RelatedOptions struct {
+	K          int     `json:"k"`
+	MaxDFRatio float64 `json:"max_df_ratio"`
+	MaxTerms   int     `json:"max_terms"`
+}
+
+type RelatedDoc struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+type RelatedDocs struct {
+	ID      string       `json:"id"`
+	Related []RelatedDoc `json:"related"`
+	// the doc changed since its neighbours were computed, the next refresh recomputes them
+	Pending    bool      `json:"pending,omitempty"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// RelatedSnapshot keeps the related docs so that loading skips the full
+// refresh, they are recomputed when the options differ from the loading TFIDF
+type RelatedSnapshot struct {
+	Options RelatedOptions `json:"options"`
+	Lists   []RelatedDocs  `json:"lists"`
+}
+
+type RelatedStats struct {
+	RelatedDocs    int        `json:"related_docs"`
+	RelatedPending int        `json:"related_pending"`
+	LastRelated    *time.Time `json:"last_related,omitempty"`
+}
+
+// WithRelated sets how related docs are precomputed, zero values keep defaults
+func WithRelated(opts RelatedOptions) Option {
+	return func(t *TFIDF) {
+		if opts.K > 0 {
+			t.related.opts.K = opts.K
+		}
+		if opts.MaxDFRatio > 0 {
+			t.related.opts.MaxDFRatio = opts.MaxDFRatio
+		}
+		if opts.MaxTerms > 0 {
+			t.related.opts.MaxTerms = opts.MaxTerms
+		}
+	}
+}
+
+type relatedList struct {
+	docs []RelatedDoc
+	at   time.Time
+}
+
+// relatedIndex keeps the top K related docs of every doc, docs changed since
+// their list was computed are dirty until the next refresh
+type relatedIndex struct {
+	sync.Mutex
+	opts  RelatedOptions
+	lists map[string]relatedList
+	// owners of the lists every doc appears in
+	in          map[string]set
+	dirty       set
+	lastRefresh time.Time
+}
+
+func newRelatedIndex() *relatedIndex {
+	return &relatedIndex{
+		opts: RelatedOptions{
+			K:          defaultRelatedK,
+			MaxDFRatio: defaultRelatedMaxDFRatio,
+			MaxTerms:   defaultRelatedMaxTerms,
+		},
+		lists: make(map[string]relatedList),
+		in:    make(map[string]set),
+		dirty: make(set),
+	}
+}
+
+// reset drops all lists and marks every doc dirty
+func (r *relatedIndex) reset(ids []string) {
+	if r == nil {
+		return
+	}
+	defer r.Unlock()
+	r.Lock()
+	r.lists = make(map[string]relatedList)
+	r.in = make(map[string]set)
+	r.dirty = make(set, len(ids))
+	for _, id := range ids {
+		r.dirty.set(id)
+	}
+}
+
+func (r *relatedIndex) touch(id string) {
+	if r == nil {
+		return
+	}
+	defer r.Unlock()
+	r.Lock()
+	r.dirty.set(id)
+}
+
+// remove drops the list of the doc and the doc from other lists, which are
+// marked dirty to be filled up again
+func (r *relatedIndex) remove(id string) {
+	if r == nil {
+		return
+	}
+	defer r.Unlock()
+	r.Lock()
+	r.unlink(id)
+	delete(r.lists, id)
+	r.dirty.del(id)
+	for owner := range r.in[id] {
+		l := r.lists[owner]
+		for i := range l.docs {
+			if l.docs[i].ID == id {
+				l.docs = append(l.docs[:i:i], l.docs[i+1:]...)
+				break
+			}
+		}
+		r.lists[owner] = l
+		r.dirty.set(owner)
+	}
+	delete(r.in, id)
+}
+
+// take returns dirty docs and clears them
+func (r *relatedIndex) take() []string {
+	defer r.Unlock()
+	r.Lock()
+	ids := r.dirty.members()
+	r.dirty = make(set)
+	sort.Strings(ids)
+	return ids
+}
+
+// unlink removes the owner from in of docs in its list, the caller holds the lock
+func (r *relatedIndex) unlink(owner string) {
+	for _, d := range r.lists[owner].docs {
+		r.in[d.ID].del(owner)
+		if len(r.in[d.ID]) == 0 {
+			delete(r.in, d.ID)
+		}
+	}
+}
+
+// link replaces the list of the owner, the caller holds the lock
+func (r *relatedIndex) link(owner string, l relatedList) {
+	r.unlink(owner)
+	r.lists[owner] = l
+	for _, d := range l.docs {
+		if r.in[d.ID] == nil {
+			r.in[d.ID] = make(set)
+		}
+		r.in[d.ID].set(owner)
+	}
+}
+
+// put replaces the list of the owner and reports whether its docs changed
+func (r *relatedIndex) put(owner string, docs []RelatedDoc, at time.Time) bool {
+	defer r.Unlock()
+	r.Lock()
+	l, ok := r.lists[owner]
+	r.link(owner, relatedList{docs: docs, at: at})
+	return !ok || !sameRelated(l.docs, docs)
+}
+
+// sameRelated ignores rounding, dot products sum in map order
+func sameRelated(a, b []RelatedDoc) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || math.Abs(a[i].Score-b[i].Score) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+// offer updates the score of doc id in the list of owner, score 0 removes it,
+// and reports whether the list changed.
+// Lists losing a doc are marked dirty since a doc outside may take its place.
+func (r *relatedIndex) offer(owner, id string, score float64) bool {
+	defer r.Unlock()
+	r.Lock()
+	l, ok := r.lists[owner]
+	if !ok {
+		return false
+	}
+	docs := make([]RelatedDoc, 0, len(l.docs)+1)
+	found := false
+	for _, d := range l.docs {
+		if d.ID == id {
+			found = true
+			continue
+		}
+		docs = append(docs, d)
+	}
+	if score > 0 {
+		docs = append(docs, RelatedDoc{ID: id, Score: score})
+	}
+	sortRelated(docs)
+	if len(docs) > r.opts.K {
+		docs = docs[:r.opts.K]
+	}
+	kept := false
+	for _, d := range docs {
+		kept = kept || d.ID == id
+	}
+	if found && !kept {
+		r.dirty.set(owner)
+	}
+	if (!found && !kept) || sameRelated(l.docs, docs) {
+		return false
+	}
+	r.link(owner, relatedList{docs: docs, at: l.at})
+	return true
+}
+
+func (r *relatedIndex) snapshot() *RelatedSnapshot {
+	defer r.Unlock()
+	r.Lock()
+	s := &RelatedSnapshot{
+		Options: r.opts,
+		Lists:   make([]RelatedDocs, 0, len(r.lists)),
+	}
+	for id, l := range r.lists {
+		s.Lists = append(s.Lists, RelatedDocs{
+			ID:         id,
+			Related:    l.docs,
+			Pending:    r.dirty.exist(id),
+			ComputedAt: l.at,
+		})
+	}
+	sort.Slice(s.Lists, func(i, j int) bool {
+		return s.Lists[i].ID < s.Lists[j].ID
+	})
+	return s
+}
+
+// load restores the lists of the snapshot made with the same options, docs
+// without a list, with a pending one or with a related doc gone are dirty
+func (r *relatedIndex) load(ids []string, s *RelatedSnapshot) {
+	r.reset(ids)
+	if s == nil || s.Options != r.opts {
+		return
+	}
+	stored := make(set, len(ids))
+	for _, id := range ids {
+		stored.set(id)
+	}
+	defer r.Unlock()
+	r.Lock()
+	for _, l := range s.Lists {
+		if !stored.exist(l.ID) {
+			continue
+		}
+		docs := make([]RelatedDoc, 0, len(l.Related))
+		for _, d := range l.Related {
+			if stored.exist(d.ID) {
+				docs = append(docs, d)
+			}
+		}
+		r.link(l.ID, relatedList{docs: docs, at: l.ComputedAt})
+		if !l.Pending && len(docs) == len(l.Related) {
+			r.dirty.del(l.ID)
+		}
+	}
+}
+
+func (r *relatedIndex) owners(id string) []string {
+	defer r.Unlock()
+	r.Lock()
+	return r.in[id].members()
+}
+
+func sortRelated(docs []RelatedDoc) {
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].Score == docs[j].Score {
+			return docs[i].ID < docs[j].ID
+		}
+		return docs[i].Score > docs[j].Score
+	})
+}
+
+// relatedScores scores candidates from the inverted index by cosine
+// similarity, vectors are cached across calls of one refresh
+func (t *TFIDF) relatedScores(id string, vec sparseVector, cache map[string]sparseVector) map[string]float64 {
+	opts := t.related.opts
+	maxDF := opts.MaxDFRatio * float64(t.DocCount())
+
+	candidates := make(set)
+	for _, index := range vec.top(opts.MaxTerms) {
+		value, ok := t.wordValue(index)
+		if !ok {
+			continue
+		}
+		w := t.wm.getWord(value)
+		if w == nil || w.docSet == nil || float64(w.docCount()) > maxDF {
+			continue
+		}
+		w.docSet.Lock()
+		for c := range w.docSet.m {
+			candidates.set(c)
+		}
+		w.docSet.Unlock()
+	}
+	candidates.del(id)
+
+	scores := make(map[string]float64, len(candidates))
+	for c := range candidates {
+		cv, ok := cache[c]
+		if !ok {
+			doc, found := t.GetDoc(c)
+			if !found {
+				continue
+			}
+			cv = t.docVector(doc).normalize()
+			if len(cache) < maxRelatedCache {
+				cache[c] = cv
+			}
+		}
+		if score := vec.dot(cv); score > 0 {
+			scores[c] = score
+		}
+	}
+	return scores
+}
+
+func (t *TFIDF) topRelated(scores map[string]float64) []RelatedDoc {
+	docs := make([]RelatedDoc, 0, len(scores))
+	for id, score := range scores {
+		docs = append(docs, RelatedDoc{ID: id, Score: score})
+	}
+	sortRelated(docs)
+	if len(docs) > t.related.opts.K {
+		docs = docs[:t.related.opts.K]
+	}
+	return docs
+}
+
+// RefreshRelated recomputes the related docs of docs changed since the last
+// refresh and offers them to the lists of their neighbours, scores of other
+// pairs keep the IDF they were computed with. Docs left on cancellation stay
+// dirty. Lists are saved with snapshots, so a refresh changing any list marks
+// the data updated.
+func (t *TFIDF) RefreshRelated(ctx context.Context) (int, error) {
+	ids := t.related.take()
+	cache := make(map[string]sparseVector)
+	changed := false
+	defer func() {
+		if changed {
+			t.pd.Lock()
+			t.pd.updated = true
+			t.pd.Unlock()
+		}
+	}()
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			for _, left := range ids[i:] {
+				t.related.touch(left)
+			}
+			return i, err
+		}
+		doc, ok := t.GetDoc(id)
+		if !ok {
+			continue
+		}
+		if len(cache) >= maxRelatedCache {
+			cache = make(map[string]sparseVector)
+		}
+		vec := t.docVector(doc).normalize()
+		cache[id] = vec
+		scores := t.relatedScores(id, vec, cache)
+		if t.related.put(id, t.topRelated(scores), time.Now().UTC()) {
+			changed = true
+		}
+		for c, score := range scores {
+			if t.related.offer(c, id, score) {
+				changed = true
+			}
+		}
+		for _, owner := range t.related.owners(id) {
+			if _, ok := scores[owner]; !ok && t.related.offer(owner, id, 0) {
+				changed = true
+			}
+		}
+	}
+
+	t.related.Lock()
+	t.related.lastRefresh = time.Now().UTC()
+	t.related.Unlock()
+	return len(ids), nil
+}
+
+// RelatedDocs returns up to limit related docs, docs never refreshed are
+// computed on demand and stay dirty so that their neighbours get updated
+func (t *TFIDF) RelatedDocs(id string, limit int) (RelatedDocs, bool) {
+	doc, ok := t.GetDoc(id)
+	if !ok {
+		return RelatedDocs{}, false
+	}
+
+	t.related.Lock()
+	l, computed := t.related.lists[id]
+	pending := t.related.dirty.exist(id)
+	t.related.Unlock()
+	if !computed {
+		vec := t.docVector(doc).normalize()
+		scores := t.relatedScores(id, vec, make(map[string]sparseVector))
+		l = relatedList{docs: t.topRelated(scores), at: time.Now().UTC()}
+		t.related.put(id, l.docs, l.at)
+	}
+
+	res := RelatedDocs{
+		ID:         id,
+		Related:    l.docs,
+		Pending:    pending,
+		ComputedAt: l.at,
+	}
+	if limit > 0 && len(res.Related) > limit {
+		res.Related = res.Related[:limit]
+	}
+	return res, true
+}
+
+func (t *TFIDF) RelatedStats() RelatedStats {
+	defer t.related.Unlock()
+	t.related.Lock()
+	res := RelatedStats{
+		RelatedDocs:    len(t.related.lists),
+		RelatedPending: len(t.related.dirty),
+	}
+	if !t.related.lastRefresh.IsZero() {
+		last := t.related.lastRefresh
+		res.LastRelated = &last
+	}
+	return res
+}
+
+// StartRelated refreshes related docs every interval until stop is called,
+// which also cancels a refresh in progress
+func (s *Server) StartRelated(interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, err := s.engine().RefreshRelated(ctx)
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				log.Printf("refreshed related docs of %d docs", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+func (s *Server) GetRelated(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 || limit > maxPageLimit {
+		abortInvalid(ctx, invalidf("limit", "invalid limit %q, expected 0 to %d", ctx.Query("limit"), maxPageLimit))
+		return
+	}
+	res, ok := s.engine().RelatedDocs(ctx.Param("id"), limit)
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, ErrCodeNotFound, errors.New("doc not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,207 @@
+package tfidf
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func relatedIDs(t *testing.T, tfidf *TFIDF, id string) []string {
+	t.Helper()
+	res, ok := tfidf.RelatedDocs(id, 0)
+	if !ok {
+		t.Fatalf("doc %s not found", id)
+	}
+	ids := make([]string, len(res.Related))
+	for i := range res.Related {
+		ids[i] = res.Related[i].ID
+	}
+	return ids
+}
+
+func TestRelatedDocs(t *testing.T) {
+	ctx := context.Background()
+	tfidf := NewTFIDF(WithRelated(RelatedOptions{K: 2, MaxDFRatio: 1}))
+	docs := []Doc{
+		{ID: "1", Words: []string{"apple", "banana", "cherry"}},
+		{ID: "2", Words: []string{"apple", "banana", "durian"}},
+		{ID: "3", Words: []string{"apple", "elderberry"}},
+		{ID: "4", Words: []string{"fig", "grape"}},
+	}
+	// unrelated docs keep IDF of shared words positive
+	for _, w := range []string{"kiwi", "lemon", "mango", "nectarine", "orange", "papaya"} {
+		docs = append(docs, Doc{ID: w, Words: []string{w}})
+	}
+	_, err := tfidf.UpsertDocs(ctx, docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := tfidf.RefreshRelated(ctx)
+	if err != nil || n != len(docs) {
+		t.Fatalf("expected %d docs refreshed, got %d, %v", len(docs), n, err)
+	}
+	if ids := relatedIDs(t, tfidf, "1"); len(ids) != 2 || ids[0] != "2" || ids[1] != "3" {
+		t.Fatalf("expected docs 2 and 3, got %v", ids)
+	}
+	if ids := relatedIDs(t, tfidf, "4"); len(ids) != 0 {
+		t.Fatalf("expected no related docs, got %v", ids)
+	}
+
+	// a new doc is offered to the lists of its neighbours
+	_, err = tfidf.UpsertDocs(ctx, []Doc{{ID: "5", Words: []string{"fig", "grape", "apple"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := tfidf.RelatedDocs("5", 0); !res.Pending {
+		t.Fatalf("expected doc 5 pending before refresh")
+	}
+	n, err = tfidf.RefreshRelated(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 doc refreshed, got %d, %v", n, err)
+	}
+	if ids := relatedIDs(t, tfidf, "4"); len(ids) != 1 || ids[0] != "5" {
+		t.Fatalf("expected doc 5 related to doc 4, got %v", ids)
+	}
+
+	// deleted docs leave every list, which are filled up by the next refresh
+	tfidf.DeleteDoc("2")
+	for _, id := range []string{"1", "3", "4", "5"} {
+		for _, related := range relatedIDs(t, tfidf, id) {
+			if related == "2" {
+				t.Fatalf("deleted doc 2 still related to doc %s", id)
+			}
+		}
+	}
+	if stats := tfidf.RelatedStats(); stats.RelatedPending == 0 {
+		t.Fatalf("expected lists of doc 2 neighbours pending, got %+v", stats)
+	}
+	_, err = tfidf.RefreshRelated(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := relatedIDs(t, tfidf, "1"); len(ids) != 2 {
+		t.Fatalf("expected list of doc 1 filled up, got %v", ids)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = tfidf.UpsertDocs(ctx, []Doc{{ID: "6", Words: []string{"apple"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tfidf.RefreshRelated(canceled); err == nil {
+		t.Fatal("expected cancellation")
+	}
+	if stats := tfidf.RelatedStats(); stats.RelatedPending != 1 {
+		t.Fatalf("expected canceled doc to stay pending, got %+v", stats)
+	}
+}
+
+func TestRelatedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	opts := RelatedOptions{K: 2, MaxDFRatio: 1}
+	tfidf := NewTFIDF(WithRelated(opts))
+	_, err := tfidf.UpsertDocs(ctx, testDocs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tfidf.RefreshRelated(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tfidf.UpsertDocs(ctx, []Doc{{ID: "4", Words: []string{"banana"}}})
+
+	dir := t.TempDir()
+	store := NewFileStore(dir+"/data.json", dir+"/fd.json", 0600)
+	if err := tfidf.SaveTo(store); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewTFIDF(WithRelated(opts))
+	if err := loaded.LoadFromStore(store); err != nil {
+		t.Fatal(err)
+	}
+	// only the doc upserted after the refresh is left to refresh
+	if stats := loaded.RelatedStats(); stats.RelatedDocs != 3 || stats.RelatedPending != 1 {
+		t.Fatalf("expected 3 lists loaded and doc 4 pending, got %+v", stats)
+	}
+	expected, _ := tfidf.RelatedDocs("1", 0)
+	if res, _ := loaded.RelatedDocs("1", 0); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, res)
+	}
+
+	// lists of other options are recomputed
+	other := NewTFIDF(WithRelated(RelatedOptions{K: 1, MaxDFRatio: 1}))
+	if err := other.LoadFromStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if stats := other.RelatedStats(); stats.RelatedDocs != 0 || stats.RelatedPending != 4 {
+		t.Fatalf("expected every doc pending, got %+v", stats)
+	}
+}
+
+// TestRelatedConcurrentRefresh is meant for go test -race
+func TestRelatedConcurrentRefresh(t *testing.T) {
+	ctx := context.Background()
+	tfidf := NewTFIDF(WithRelated(RelatedOptions{MaxDFRatio: 1}))
+	rnd := rand.New(rand.NewSource(1))
+	docs := topicDocs(rnd, 200, 5, 20, 10)
+	if _, err := tfidf.UpsertDocs(ctx, docs[:50]); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 50; i < len(docs); i += 10 {
+			if _, err := tfidf.UpsertDocs(ctx, docs[i:i+10]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := tfidf.Search(ctx, SearchRequest{Doc: docs[i], Limit: 5}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			if _, err := tfidf.RefreshRelated(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if _, err := tfidf.RefreshRelated(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats := tfidf.RelatedStats(); stats.RelatedDocs != len(docs) || stats.RelatedPending != 0 {
+		t.Fatalf("expected every doc refreshed, got %+v", stats)
+	}
+
+	// a refresh changing no list leaves nothing to save, the first one
+	// catches up with the IDF of docs upserted during the refresh above
+	tfidf.related.touch(docs[0].ID)
+	if _, err := tfidf.RefreshRelated(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tfidf.pd.Lock()
+	tfidf.pd.updated = false
+	tfidf.pd.Unlock()
+	tfidf.related.touch(docs[0].ID)
+	if _, err := tfidf.RefreshRelated(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tfidf.pd.updated {
+		t.Fatal("expected an unchanged refresh not to mark the data updated")
+	}
+}
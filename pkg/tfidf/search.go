@@ -0,0 +1,170 @@
+package tfidf
+
+import (
+	"context"
+	"sort"
+)
+
+const defaultSearchLimit = 10
+
+type SearchRequest struct {
+	Doc     Doc  `json:"doc"`
+	Limit   int  `json:"limit,omitempty"`
+	Explain bool `json:"explain,omitempty"`
+	// unknown words are corrected within the edit distance, 0 disables correction
+	Fuzzy int `json:"fuzzy,omitempty"`
+	// candidates come from the LSH index instead of the inverted index,
+	// fewer tables and probes are faster but miss more of the true neighbours
+	Approximate bool `json:"approximate,omitempty"`
+	// hash tables looked up, all tables of the index by default
+	Tables int `json:"tables,omitempty"`
+	// extra buckets looked up per table
+	Probes int `json:"probes,omitempty"`
+}
+
+// TermContribution is the share of a word in a cosine similarity
+type TermContribution struct {
+	Word         string  `json:"word"`
+	Index        int     `json:"index"`
+	QueryWeight  float64 `json:"query_weight"`
+	DocWeight    float64 `json:"doc_weight"`
+	Contribution float64 `json:"contribution"`
+	// the query word this synonym was expanded from
+	ExpandedFrom string `json:"expanded_from,omitempty"`
+}
+
+type SearchHit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+	// contributions sorted by descending share, summing up to Score
+	Contributions []TermContribution `json:"contributions,omitempty"`
+	Formula       string             `json:"formula,omitempty"`
+	// query-time synonyms added to the doc, mapped to the words they expand
+	Expansions map[string]string `json:"expansions,omitempty"`
+}
+
+// Search ranks stored docs by cosine similarity of TF-IDF vectors to the doc,
+// which is not upserted. Candidates are docs sharing at least one word, or
+// docs hashed close to the doc by the LSH index when approximate.
+func (t *TFIDF) Search(ctx context.Context, req SearchRequest) ([]SearchHit, error) {
+	if req.Limit <= 0 {
+		req.Limit = defaultSearchLimit
+	}
+	if req.Approximate {
+		if err := t.ann.validateSearch(req); err != nil {
+			return nil, err
+		}
+		if req.Tables == 0 {
+			req.Tables = t.ann.opts.Tables
+		}
+	}
+	req.Doc = t.analyze(req.Doc)
+	if req.Fuzzy > 0 {
+		req.Doc.Words = t.correctWords(req.Doc.Words, req.Fuzzy)
+		fields := make(map[string][]string, len(req.Doc.Fields))
+		for field, words := range req.Doc.Fields {
+			fields[field] = t.correctWords(words, req.Fuzzy)
+		}
+		req.Doc.Fields = fields
+	}
+	expanded := make(map[string]string)
+	if t.synonymMode == SynonymsAtQuery {
+		req.Doc = t.synonyms.expandDoc(req.Doc, expanded)
+	}
+	query := t.docVector(req.Doc).normalize()
+
+	var candidates set
+	if req.Approximate {
+		candidates = t.ann.candidates(query, req.Tables, req.Probes)
+	} else {
+		candidates = t.wordCandidates(query)
+	}
+
+	hits := make([]SearchHit, 0, len(candidates))
+	vectors := make(map[string]sparseVector, len(candidates))
+	for id := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		doc, ok := t.GetDoc(id)
+		if !ok {
+			continue
+		}
+		vec := t.docVector(doc).normalize()
+		score := query.dot(vec)
+		if score <= 0 {
+			continue
+		}
+		vectors[id] = vec
+		hits = append(hits, SearchHit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score == hits[j].Score {
+			return hits[i].ID < hits[j].ID
+		}
+		return hits[i].Score > hits[j].Score
+	})
+	if len(hits) > req.Limit {
+		hits = hits[:req.Limit]
+	}
+
+	if req.Explain {
+		for i := range hits {
+			hits[i].Contributions = t.contributions(query, vectors[hits[i].ID])
+			hits[i].Formula = cosineFormula
+			for j := range hits[i].Contributions {
+				hits[i].Contributions[j].ExpandedFrom = expanded[hits[i].Contributions[j].Word]
+			}
+			if len(expanded) > 0 {
+				hits[i].Expansions = expanded
+			}
+		}
+	}
+	return hits, nil
+}
+
+// wordCandidates collects docs sharing a word with the query
+func (t *TFIDF) wordCandidates(query sparseVector) set {
+	candidates := make(set)
+	for index := range query {
+		value, ok := t.wordValue(index)
+		if !ok {
+			continue
+		}
+		ds := t.wm.getWord(value).docSet
+		if ds == nil {
+			continue
+		}
+		ds.Lock()
+		for id := range ds.m {
+			candidates.set(id)
+		}
+		ds.Unlock()
+	}
+	return candidates
+}
+
+func (t *TFIDF) contributions(query, vec sparseVector) []TermContribution {
+	res := make([]TermContribution, 0)
+	for index, q := range query {
+		d, ok := vec[index]
+		if !ok {
+			continue
+		}
+		value, _ := t.wordValue(index)
+		res = append(res, TermContribution{
+			Word:         value,
+			Index:        index,
+			QueryWeight:  q,
+			DocWeight:    d,
+			Contribution: q * d,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Contribution == res[j].Contribution {
+			return res[i].Index < res[j].Index
+		}
+		return res[i].Contribution > res[j].Contribution
+	})
+	return res
+}
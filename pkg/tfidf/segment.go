@@ -0,0 +1,170 @@
+package tfidf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Analyzer splits raw text into words
+type Analyzer interface {
+	Analyze(text string) []string
+}
+
+// WithAnalyzer sets the analyzer of `Doc.Text`, by default text is split by
+// NewSegmenter(nil), i.e. letters and digits runs plus CJK bigrams
+func WithAnalyzer(a Analyzer) Option {
+	return func(t *TFIDF) {
+		t.analyzer = a
+	}
+}
+
+// Segmenter segments CJK text by maximum probability over a word-frequency
+// dictionary, runs of characters unknown to the dictionary fall back to
+// overlapping bigrams. Other text is split into lowercase letters and digits runs.
+type Segmenter struct {
+	// frequency of words, prefixes of words are kept with 0 frequency
+	freq  map[string]int
+	total int
+}
+
+// NewSegmenter builds a segmenter from dictionary lines of `word [freq] [tag]`,
+// which is the format of jieba dictionaries, freq defaults to 1
+func NewSegmenter(dict io.Reader) (*Segmenter, error) {
+	s := &Segmenter{
+		freq: make(map[string]int),
+	}
+	if dict == nil {
+		return s, nil
+	}
+	scanner := bufio.NewScanner(dict)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		freq := 1
+		if len(fields) > 1 {
+			f, err := strconv.Atoi(fields[1])
+			if err != nil || f < 0 {
+				return nil, fmt.Errorf("invalid frequency %q at line %d", fields[1], line)
+			}
+			freq = f
+		}
+		s.addWord(fields[0], freq)
+	}
+	return s, scanner.Err()
+}
+
+// LoadSegmenter reads the dictionary file
+func LoadSegmenter(filename string) (*Segmenter, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewSegmenter(f)
+}
+
+func (s *Segmenter) addWord(word string, freq int) {
+	s.freq[word] += freq
+	s.total += freq
+	runes := []rune(word)
+	for i := 1; i < len(runes); i++ {
+		prefix := string(runes[:i])
+		if _, ok := s.freq[prefix]; !ok {
+			s.freq[prefix] = 0
+		}
+	}
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func (s *Segmenter) Analyze(text string) []string {
+	res := make([]string, 0)
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case isCJK(r):
+			j := i
+			for j < len(runes) && isCJK(runes[j]) {
+				j++
+			}
+			res = append(res, s.segmentCJK(runes[i:j])...)
+			i = j
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && !isCJK(runes[j]) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			res = append(res, strings.ToLower(string(runes[i:j])))
+			i = j
+		default:
+			i++
+		}
+	}
+	return res
+}
+
+// segmentCJK finds the route of maximum probability over the DAG of dictionary
+// words, then turns runs of unknown single characters into bigrams
+func (s *Segmenter) segmentCJK(runes []rune) []string {
+	n := len(runes)
+	logTotal := math.Log(float64(s.total + 1))
+	// route[i] is the best log probability of runes[i:] and the end of its first word
+	type step struct {
+		logP float64
+		end  int
+	}
+	route := make([]step, n+1)
+	for i := n - 1; i >= 0; i-- {
+		// a single character is always a candidate, unknown ones weigh least
+		route[i] = step{logP: math.Log(float64(s.freq[string(runes[i])]+1)) - logTotal + route[i+1].logP, end: i + 1}
+		for j := i + 2; j <= n; j++ {
+			freq, ok := s.freq[string(runes[i:j])]
+			if !ok {
+				break
+			}
+			if freq == 0 {
+				continue
+			}
+			logP := math.Log(float64(freq)) - logTotal + route[j].logP
+			if logP > route[i].logP {
+				route[i] = step{logP: logP, end: j}
+			}
+		}
+	}
+
+	res := make([]string, 0, n)
+	var unknown []rune
+	flush := func() {
+		if len(unknown) == 1 {
+			res = append(res, string(unknown))
+		}
+		for k := 0; k+1 < len(unknown); k++ {
+			res = append(res, string(unknown[k:k+2]))
+		}
+		unknown = unknown[:0]
+	}
+	for i := 0; i < n; i = route[i].end {
+		word := runes[i:route[i].end]
+		if len(word) == 1 && s.freq[string(word)] == 0 {
+			unknown = append(unknown, word[0])
+			continue
+		}
+		flush()
+		res = append(res, string(word))
+	}
+	flush()
+	return res
+}
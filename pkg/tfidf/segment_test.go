@@ -0,0 +1,47 @@
+package tfidf
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSegmenter(t *testing.T) {
+	bigrams, err := NewSegmenter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := NewSegmenter(strings.NewReader("北京 100 ns\n大学 80\n北京大学 50 nt\n学生 60\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		s        *Segmenter
+		text     string
+		expected []string
+	}{
+		{bigrams, "我爱北京 Hello, World2!", []string{"我爱", "爱北", "北京", "hello", "world2"}},
+		{bigrams, "京", []string{"京"}},
+		// the whole word is more probable than its parts
+		{dict, "北京大学", []string{"北京大学"}},
+		// unknown runs fall back to bigrams, a single unknown character is kept
+		{dict, "我爱北京", []string{"我爱", "北京"}},
+		{dict, "好学生abc大学", []string{"好", "学生", "abc", "大学"}},
+	}
+	for _, c := range cases {
+		if words := c.s.Analyze(c.text); !reflect.DeepEqual(words, c.expected) {
+			t.Fatalf("%q expected %q, got %q", c.text, c.expected, words)
+		}
+	}
+
+	tfidf := NewTFIDF(WithAnalyzer(dict))
+	_, err = tfidf.UpsertDocs(context.Background(), []Doc{{ID: "1", Words: []string{"pku"}, Text: "北京大学学生"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := tfidf.GetDoc("1")
+	if !reflect.DeepEqual(doc.Words, []string{"pku", "北京大学", "学生"}) {
+		t.Fatalf("expected words of the text appended, got %q", doc.Words)
+	}
+}
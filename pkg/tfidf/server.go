@@ -1,28 +1,57 @@
 package tfidf
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Server struct {
-	tfidf *TFIDF
+	// guards swapping tfidf on restore
+	mu     sync.RWMutex
+	tfidf  *TFIDF
+	opts   []Option
+	limits Limits
 }
 
-func NewServer(pdFilename, fdFilename string) (*Server, error) {
+type Statistics struct {
+	DocCount  int `json:"doc_count"`
+	WordCount int `json:"word_count"`
+	ExpiryStats
+	RelatedStats
+	ANNStats
+}
+
+type LSAFitResult struct {
+	Components int       `json:"components"`
+	Singular   []float64 `json:"singular"`
+	DocCount   int       `json:"doc_count"`
+	TermCount  int       `json:"term_count"`
+}
+
+// EmbedRequest embeds stored docs by id and ad-hoc docs without upserting them
+type EmbedRequest struct {
+	IDs  []string `json:"ids"`
+	Docs []Doc    `json:"docs"`
+}
+
+func NewServer(pdFilename, fdFilename string, opts ...Option) (*Server, error) {
 	s := &Server{
-		tfidf: NewTFIDF(),
+		tfidf: NewTFIDF(opts...),
+		opts:  opts,
 	}
 	_, err := os.Stat(pdFilename)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	} else if os.IsNotExist(err) {
-		err = ioutil.WriteFile(pdFilename, []byte("{}"), 0777)
+		err = ioutil.WriteFile(pdFilename, []byte("{}"), s.tfidf.fileMode)
 		if err != nil {
 			return nil, err
 		}
@@ -32,7 +61,7 @@ func NewServer(pdFilename, fdFilename string) (*Server, error) {
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	} else if os.IsNotExist(err) {
-		err = ioutil.WriteFile(fdFilename, []byte("{}"), 0777)
+		err = ioutil.WriteFile(fdFilename, []byte("{}"), s.tfidf.fileMode)
 		if err != nil {
 			return nil, err
 		}
@@ -43,41 +72,461 @@ func NewServer(pdFilename, fdFilename string) (*Server, error) {
 	return s, err
 }
 
+// NewServerWithStore loads data from the store, e.g. a SQLStore shared by several servers
+func NewServerWithStore(store Store, opts ...Option) (*Server, error) {
+	s := &Server{
+		tfidf: NewTFIDF(opts...),
+		opts:  opts,
+	}
+	log.Println("start loading data from store...")
+	err := s.tfidf.LoadFromStore(store)
+	return s, err
+}
+
+// engine returns the TFIDF currently served
+func (s *Server) engine() *TFIDF {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tfidf
+}
+
+// writer returns the TFIDF currently served to a handler changing docs,
+// Restore waits for done so that no acknowledged change lands on a
+// replaced TFIDF. Models trained or fitted during a restore are lost.
+func (s *Server) writer() (t *TFIDF, done func()) {
+	s.mu.RLock()
+	return s.tfidf, s.mu.RUnlock
+}
+
+// Register mounts all handlers of the server on the router
+func (s *Server) Register(router gin.IRoutes) {
+	for _, r := range s.routes() {
+		router.Handle(r.method, r.path, s.limit(r), r.handler)
+	}
+	spec := s.OpenAPI()
+	router.GET("/openapi.json", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, spec)
+	})
+}
+
 func (s *Server) Save(pdFilename, fdFilename string) error {
-	return s.tfidf.Save(pdFilename, fdFilename)
+	return s.engine().Save(pdFilename, fdFilename)
+}
+
+func (s *Server) SaveTo(store Store) error {
+	return s.engine().SaveTo(store)
+}
+
+// abortWithError responds ErrorBody, field of validation errors is reported
+func abortWithError(ctx *gin.Context, status int, code string, err error) {
+	body := ErrorBody{
+		Code:    code,
+		Message: err.Error(),
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		body.Field = verr.Field
+		body.Message = verr.Message
+	}
+	ctx.AbortWithStatusJSON(status, body)
+}
+
+func abortInvalid(ctx *gin.Context, err error) {
+	abortWithError(ctx, http.StatusBadRequest, ErrCodeInvalidParameter, err)
+}
+
+func bindJSON(ctx *gin.Context, req interface{}) bool {
+	err := ctx.ShouldBindJSON(req)
+	if err != nil {
+		log.Println(err)
+		if abortIfDone(ctx, err) {
+			return false
+		}
+		abortWithError(ctx, http.StatusBadRequest, ErrCodeInvalidJSON, err)
+		return false
+	}
+	return true
+}
+
+func queryFloat(ctx *gin.Context, name string, def float64) (float64, error) {
+	s, ok := ctx.GetQuery(name)
+	if !ok {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, invalidf(name, "invalid number %q", s)
+	}
+	return v, nil
+}
+
+func queryBool(ctx *gin.Context, name string) (bool, error) {
+	s, ok := ctx.GetQuery(name)
+	if !ok {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, invalidf(name, "invalid boolean %q", s)
+	}
+	return v, nil
 }
 
 func (s *Server) UpsertDocs(ctx *gin.Context) {
 	req := []Doc{}
-	err := ctx.ShouldBindJSON(&req)
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	err := validateDocs(req, true)
 	if err != nil {
-		log.Println(err)
-		ctx.JSON(http.StatusBadRequest, fmt.Sprintf("invalid parameters, %s", err.Error()))
+		abortInvalid(ctx, err)
+		return
+	}
+
+	policy, err := ParseDuplicatePolicy(ctx.Query("on_duplicate"))
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	t, done := s.writer()
+	defer done()
+	if policy == DuplicateAllow {
+		n, err := t.UpsertDocs(ctx.Request.Context(), req)
+		if err != nil {
+			abortFailed(ctx, fmt.Errorf("%d of %d docs upserted, %w", n, len(req), err))
+			return
+		}
+		ctx.JSON(http.StatusOK, "ok")
 		return
 	}
 
-	s.tfidf.UpsertDocs(req)
-	ctx.JSON(http.StatusOK, "ok")
+	threshold, err := queryFloat(ctx, "threshold", DefaultDuplicateThreshold)
+	if err == nil && (threshold <= 0 || threshold > 1) {
+		err = invalidf("threshold", "threshold %v out of range (0, 1]", threshold)
+	}
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	res, err := t.UpsertDocsWithPolicy(ctx.Request.Context(), req, policy, threshold)
+	if err != nil {
+		abortFailed(ctx, fmt.Errorf("%d of %d docs upserted, %w", res.Upserted+len(res.Replaced), len(req), err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
 }
 
 func (s *Server) GetDocVector(ctx *gin.Context) {
 	req := Doc{}
-	err := ctx.ShouldBindJSON(&req)
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	err := req.validate("", true)
 	if err != nil {
-		log.Println(err)
-		ctx.JSON(http.StatusBadRequest, fmt.Sprintf("invalid parameters, %s", err.Error()))
+		abortInvalid(ctx, err)
+		return
+	}
+	explain, err := queryBool(ctx, "explain")
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+
+	t, done := s.writer()
+	defer done()
+	var res []*WordTFIDF
+	if explain {
+		res, err = t.ExplainDocVector(ctx.Request.Context(), req)
+	} else {
+		res, err = t.GetDocVector(ctx.Request.Context(), req)
+	}
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (s *Server) Search(ctx *gin.Context) {
+	req := SearchRequest{}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	err := req.Doc.validate("doc.", false)
+	if err == nil && (req.Fuzzy < 0 || req.Fuzzy > MaxFuzzyDistance) {
+		err = invalidf("fuzzy", "fuzzy should be 0 to %d", MaxFuzzyDistance)
+	}
+	if err != nil {
+		abortInvalid(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, s.tfidf.GetDocVector(req))
+	res, err := s.engine().Search(ctx.Request.Context(), req)
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
 }
 
 func (s *Server) GetStatistics(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, struct {
-		DocCount  int `json:"doc_count"`
-		WordCount int `json:"word_count"`
-	}{
-		s.tfidf.DocCount(),
-		s.tfidf.WordCount(),
+	t := s.engine()
+	ctx.JSON(http.StatusOK, Statistics{
+		DocCount:     t.DocCount(),
+		WordCount:    t.WordCount(),
+		ExpiryStats:  t.ExpiryStats(),
+		RelatedStats: t.RelatedStats(),
+		ANNStats:     t.ANNStats(),
+	})
+}
+
+func (s *Server) GetAnalytics(ctx *gin.Context) {
+	top, err := strconv.Atoi(ctx.DefaultQuery("top", strconv.Itoa(DefaultAnalyticsTop)))
+	if err != nil || top < 0 || top > maxPageLimit {
+		abortInvalid(ctx, invalidf("top", "invalid top %q, expected 0 to %d", ctx.Query("top"), maxPageLimit))
+		return
+	}
+	buckets, err := strconv.Atoi(ctx.DefaultQuery("buckets", strconv.Itoa(DefaultAnalyticsBuckets)))
+	if err != nil || buckets <= 0 || buckets > maxPageLimit {
+		abortInvalid(ctx, invalidf("buckets", "invalid buckets %q, expected 1 to %d", ctx.Query("buckets"), maxPageLimit))
+		return
+	}
+	ctx.JSON(http.StatusOK, s.engine().Analytics(top, buckets))
+}
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 1000
+)
+
+func pagination(ctx *gin.Context) (offset, limit int, err error) {
+	offset, err = strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		return 0, 0, invalidf("offset", "invalid offset %q", ctx.Query("offset"))
+	}
+	limit, err = strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		return 0, 0, invalidf("limit", "invalid limit %q, expected 1 to %d", ctx.Query("limit"), maxPageLimit)
+	}
+	return offset, limit, nil
+}
+
+func (s *Server) GetDoc(ctx *gin.Context) {
+	doc, ok := s.engine().GetDoc(ctx.Param("id"))
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, ErrCodeNotFound, errors.New("doc not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, doc)
+}
+
+func (s *Server) GetStoredDocVector(ctx *gin.Context) {
+	explain, err := queryBool(ctx, "explain")
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	res, ok := s.engine().StoredDocVector(ctx.Param("id"), explain)
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, ErrCodeNotFound, errors.New("doc not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (s *Server) ListDocs(ctx *gin.Context) {
+	offset, limit, err := pagination(ctx)
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	page, err := s.engine().ListDocs(offset, limit, ctx.Query("sort"))
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func (s *Server) GetWord(ctx *gin.Context) {
+	info, ok := s.engine().LookupWord(ctx.Param("word"))
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, ErrCodeNotFound, errors.New("word not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, info)
+}
+
+func (s *Server) GetWordByIndex(ctx *gin.Context) {
+	i, err := strconv.Atoi(ctx.Param("i"))
+	if err != nil {
+		abortInvalid(ctx, invalidf("i", "invalid index %q", ctx.Param("i")))
+		return
+	}
+	info, ok := s.engine().WordByIndex(i)
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, ErrCodeNotFound, errors.New("word not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, info)
+}
+
+func (s *Server) ListWords(ctx *gin.Context) {
+	offset, limit, err := pagination(ctx)
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	page, err := s.engine().ListWords(offset, limit, ctx.Query("sort"))
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func (s *Server) Cluster(ctx *gin.Context) {
+	req := ClusterOptions{}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	res, err := s.engine().Cluster(ctx.Request.Context(), req)
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (s *Server) GetDuplicates(ctx *gin.Context) {
+	threshold, err := queryFloat(ctx, "threshold", DefaultDuplicateThreshold)
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+
+	res, err := s.engine().NearDuplicates(ctx.Request.Context(), threshold)
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (s *Server) Query(ctx *gin.Context) {
+	offset, limit, err := pagination(ctx)
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+
+	explain, err := queryBool(ctx, "explain")
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+
+	res, err := s.engine().Query(ctx.Request.Context(), ctx.Query("q"), offset, limit, explain)
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (s *Server) Suggest(ctx *gin.Context) {
+	word := ctx.Query("word")
+	if word == "" {
+		abortInvalid(ctx, invalidf("word", "word is required"))
+		return
+	}
+	maxDistance, err := strconv.Atoi(ctx.DefaultQuery("max_distance", strconv.Itoa(defaultFuzzyDistance)))
+	if err != nil {
+		abortInvalid(ctx, invalidf("max_distance", "invalid max_distance %q", ctx.Query("max_distance")))
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultSuggestLimit)))
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		abortInvalid(ctx, invalidf("limit", "invalid limit %q, expected 1 to %d", ctx.Query("limit"), maxPageLimit))
+		return
+	}
+
+	res, err := s.engine().Suggest(word, maxDistance, limit)
+	if err != nil {
+		abortInvalid(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// LoadLSA loads the persisted lsa model if there is one
+func (s *Server) LoadLSA(filename string) error {
+	_, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.engine().LoadLSA(filename)
+}
+
+func (s *Server) SaveLSA(filename string) error {
+	return s.engine().SaveLSA(filename)
+}
+
+func (s *Server) FitLSA(ctx *gin.Context) {
+	req := LSAOptions{}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	model, err := s.engine().FitLSA(ctx.Request.Context(), req)
+	if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, LSAFitResult{
+		Components: model.Components,
+		Singular:   model.Singular,
+		DocCount:   model.DocCount,
+		TermCount:  len(model.Terms),
 	})
 }
+
+func (s *Server) EmbedLSA(ctx *gin.Context) {
+	req := EmbedRequest{}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if len(req.IDs) == 0 && len(req.Docs) == 0 {
+		abortInvalid(ctx, invalidf("", "at least one id or doc is required"))
+		return
+	}
+	for i := range req.Docs {
+		err := req.Docs[i].validate(fmt.Sprintf("docs[%d].", i), false)
+		if err != nil {
+			abortInvalid(ctx, err)
+			return
+		}
+	}
+
+	stored, err := s.engine().EmbedStored(ctx.Request.Context(), req.IDs)
+	if err == ErrLSANotFitted {
+		abortWithError(ctx, http.StatusConflict, ErrCodeNotReady, err)
+		return
+	} else if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	adhoc, err := s.engine().Embed(ctx.Request.Context(), req.Docs)
+	if err == ErrLSANotFitted {
+		abortWithError(ctx, http.StatusConflict, ErrCodeNotReady, err)
+		return
+	} else if err != nil {
+		abortFailed(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, append(stored, adhoc...))
+}
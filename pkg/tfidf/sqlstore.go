@@ -0,0 +1,260 @@
+package tfidf
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const sqlStoreBatchSize = 500
+
+type sqlDoc struct {
+	Seq       int                 `gorm:"primaryKey;autoIncrement:false"`
+	DocID     string              `gorm:"uniqueIndex;size:255;not null"`
+	Words     []string            `gorm:"serializer:json"`
+	Fields    map[string][]string `gorm:"serializer:json"`
+	Synonyms  map[string][]string `gorm:"serializer:json"`
+	ExpiresAt *time.Time
+	Labels    []string `gorm:"serializer:json"`
+}
+
+func (sqlDoc) TableName() string {
+	return "tfidf_docs"
+}
+
+type sqlWord struct {
+	Seq   int    `gorm:"primaryKey;autoIncrement:false"`
+	Value string `gorm:"not null"`
+	Order int    `gorm:"column:word_order;not null"`
+}
+
+func (sqlWord) TableName() string {
+	return "tfidf_words"
+}
+
+type sqlGrowthPoint struct {
+	Seq int `gorm:"primaryKey;autoIncrement:false"`
+	GrowthPoint
+}
+
+func (sqlGrowthPoint) TableName() string {
+	return "tfidf_growth"
+}
+
+// sqlModel keeps models trained from the corpus as JSON
+type sqlModel struct {
+	Name string `gorm:"primaryKey;size:64"`
+	Data []byte
+}
+
+func (sqlModel) TableName() string {
+	return "tfidf_models"
+}
+
+type sqlCounter struct {
+	Name  string `gorm:"primaryKey;size:64"`
+	Value int
+}
+
+func (sqlCounter) TableName() string {
+	return "tfidf_counters"
+}
+
+// SQLStore keeps snapshots in tfidf_docs, tfidf_words, tfidf_growth, tfidf_models
+// and tfidf_counters tables
+// of any database supported by gorm. Every Save rewrites the tables in one
+// transaction, so any number of readers may Load from the same database.
+// Rewriting costs O(docs + words) per save whatever changed, TFIDF.SaveTo
+// skips saves without changes, larger corpora want a longer save interval.
+type SQLStore struct {
+	db *gorm.DB
+}
+
+// NewSQLStore migrates the tables
+func NewSQLStore(db *gorm.DB) (*SQLStore, error) {
+	err := db.AutoMigrate(&sqlDoc{}, &sqlWord{}, &sqlGrowthPoint{}, &sqlModel{}, &sqlCounter{})
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Load() (*Snapshot, error) {
+	var docs []sqlDoc
+	var words []sqlWord
+	var growth []sqlGrowthPoint
+	var models []sqlModel
+	var counters []sqlCounter
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Order("seq").Find(&docs).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Order("seq").Find(&words).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Order("seq").Find(&growth).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Find(&models).Error
+		if err != nil {
+			return err
+		}
+		return tx.Find(&counters).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Docs:       make([]Doc, len(docs)),
+		Words:      make([]string, len(words)),
+		WordOrders: make([]int, len(words)),
+		Growth:     make([]GrowthPoint, len(growth)),
+	}
+	for i := range docs {
+		snapshot.Docs[i] = Doc{
+			ID:        docs[i].DocID,
+			Words:     docs[i].Words,
+			Fields:    docs[i].Fields,
+			Synonyms:  docs[i].Synonyms,
+			ExpiresAt: docs[i].ExpiresAt,
+			Labels:    docs[i].Labels,
+		}
+	}
+	for i := range words {
+		snapshot.Words[i] = words[i].Value
+		snapshot.WordOrders[i] = words[i].Order
+	}
+	for i := range growth {
+		snapshot.Growth[i] = growth[i].GrowthPoint
+	}
+	for _, m := range models {
+		switch m.Name {
+		case "classifier":
+			snapshot.Classifier = &ClassifierModel{}
+			err = json.Unmarshal(m.Data, snapshot.Classifier)
+		case "ann":
+			snapshot.ANN = &ANNSnapshot{}
+			err = json.Unmarshal(m.Data, snapshot.ANN)
+		case "related":
+			snapshot.Related = &RelatedSnapshot{}
+			err = json.Unmarshal(m.Data, snapshot.Related)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range counters {
+		switch c.Name {
+		case "doc_count":
+			snapshot.DocCount = c.Value
+		case "word_count":
+			snapshot.WordCount = c.Value
+		case "upserts":
+			snapshot.Upserts = c.Value
+		case "ngram_min":
+			snapshot.NGramMin = c.Value
+		case "ngram_max":
+			snapshot.NGramMax = c.Value
+		}
+	}
+	return snapshot, nil
+}
+
+func (s *SQLStore) Save(snapshot *Snapshot) error {
+	docs := make([]sqlDoc, len(snapshot.Docs))
+	for i := range snapshot.Docs {
+		docs[i] = sqlDoc{
+			Seq:       i,
+			DocID:     snapshot.Docs[i].ID,
+			Words:     snapshot.Docs[i].Words,
+			Fields:    snapshot.Docs[i].Fields,
+			Synonyms:  snapshot.Docs[i].Synonyms,
+			ExpiresAt: snapshot.Docs[i].ExpiresAt,
+			Labels:    snapshot.Docs[i].Labels,
+		}
+	}
+	words := make([]sqlWord, len(snapshot.Words))
+	for i := range snapshot.Words {
+		words[i] = sqlWord{
+			Seq:   i,
+			Value: snapshot.Words[i],
+			Order: 1,
+		}
+		if i < len(snapshot.WordOrders) {
+			words[i].Order = snapshot.WordOrders[i]
+		}
+	}
+	counters := []sqlCounter{
+		{Name: "doc_count", Value: snapshot.DocCount},
+		{Name: "word_count", Value: snapshot.WordCount},
+		{Name: "upserts", Value: snapshot.Upserts},
+		{Name: "ngram_min", Value: snapshot.NGramMin},
+		{Name: "ngram_max", Value: snapshot.NGramMax},
+	}
+	models := make([]sqlModel, 0, 3)
+	if snapshot.Classifier != nil {
+		data, err := json.Marshal(snapshot.Classifier)
+		if err != nil {
+			return err
+		}
+		models = append(models, sqlModel{Name: "classifier", Data: data})
+	}
+	if snapshot.ANN != nil {
+		data, err := json.Marshal(snapshot.ANN)
+		if err != nil {
+			return err
+		}
+		models = append(models, sqlModel{Name: "ann", Data: data})
+	}
+	if snapshot.Related != nil {
+		data, err := json.Marshal(snapshot.Related)
+		if err != nil {
+			return err
+		}
+		models = append(models, sqlModel{Name: "related", Data: data})
+	}
+	growth := make([]sqlGrowthPoint, len(snapshot.Growth))
+	for i := range snapshot.Growth {
+		growth[i] = sqlGrowthPoint{Seq: i, GrowthPoint: snapshot.Growth[i]}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+		for _, model := range []interface{}{&sqlDoc{}, &sqlWord{}, &sqlGrowthPoint{}, &sqlModel{}, &sqlCounter{}} {
+			err := tx.Delete(model).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(docs) > 0 {
+			err := tx.CreateInBatches(docs, sqlStoreBatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(words) > 0 {
+			err := tx.CreateInBatches(words, sqlStoreBatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(growth) > 0 {
+			err := tx.CreateInBatches(growth, sqlStoreBatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(models) > 0 {
+			err := tx.Create(&models).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&counters).Error
+	})
+}
@@ -0,0 +1,129 @@
+package tfidf
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openSQLStore(t *testing.T, filename string) *SQLStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func testDocs() []Doc {
+	return []Doc{
+		{ID: "1", Words: []string{"apple", "banana", "apple"}},
+		{ID: "2", Words: []string{"banana", "cherry"}, Fields: map[string][]string{"title": {"fruit"}}},
+		{ID: "3", Words: []string{"durian"}},
+	}
+}
+
+func TestSQLStoreRoundTrip(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "tfidf.db"))
+
+	writer := NewTFIDF(WithNGramRange(1, 2))
+	writer.UpsertDocs(context.Background(), testDocs())
+	err := writer.SaveTo(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewTFIDF(WithNGramRange(1, 2))
+	err = reader.LoadFromStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(writer.Snapshot(), reader.Snapshot()) {
+		t.Fatalf("snapshot mismatch\nwant %+v\ngot  %+v", writer.Snapshot(), reader.Snapshot())
+	}
+
+	doc := Doc{ID: "q", Words: []string{"apple", "cherry"}}
+	want := writer.TFVector(doc)
+	got := reader.TFVector(doc)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("tf vector mismatch, want %v, got %v", want, got)
+	}
+	if reader.IDF("banana") != writer.IDF("banana") {
+		t.Fatalf("idf mismatch, want %v, got %v", writer.IDF("banana"), reader.IDF("banana"))
+	}
+}
+
+func TestSQLStoreSaveReplaces(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tfidf.db")
+	store := openSQLStore(t, filename)
+
+	tf := NewTFIDF()
+	tf.UpsertDocs(context.Background(), testDocs())
+	err := tf.SaveTo(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf.UpsertDocs(context.Background(), []Doc{{ID: "1", Words: []string{"elderberry"}}})
+	err = tf.SaveTo(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another connection acts as an independent reader
+	s, err := openSQLStore(t, filename).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DocCount != 3 || len(s.Docs) != 3 {
+		t.Fatalf("want 3 docs, got %d (%d)", len(s.Docs), s.DocCount)
+	}
+	if !reflect.DeepEqual(s.Docs[0].Words, []string{"elderberry"}) {
+		t.Fatalf("doc 1 not replaced, got %v", s.Docs[0].Words)
+	}
+	if s.WordCount != len(s.Words) || s.Words[len(s.Words)-1] != "elderberry" {
+		t.Fatalf("unexpected words %v (%d)", s.Words, s.WordCount)
+	}
+}
+
+func TestSQLStoreEmpty(t *testing.T) {
+	store := openSQLStore(t, filepath.Join(t.TempDir(), "tfidf.db"))
+	tf := NewTFIDF()
+	err := tf.LoadFromStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.DocCount() != 0 || tf.WordCount() != 0 {
+		t.Fatalf("want empty corpus, got %d docs and %d words", tf.DocCount(), tf.WordCount())
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(filepath.Join(dir, "tfidf.json"), filepath.Join(dir, "fd.json"), 0600)
+
+	writer := NewTFIDF()
+	writer.UpsertDocs(context.Background(), testDocs())
+	err := writer.SaveTo(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := NewTFIDF()
+	err = reader.LoadFromStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(writer.Snapshot(), reader.Snapshot()) {
+		t.Fatalf("snapshot mismatch\nwant %+v\ngot  %+v", writer.Snapshot(), reader.Snapshot())
+	}
+}
@@ -0,0 +1,275 @@
+package tfidf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot is the persistent data of a TFIDF, everything else is derived from it
+type Snapshot struct {
+	DocCount  int `json:"doc_count,omitempty"`
+	WordCount int `json:"word_count,omitempty"`
+
+	Docs  []Doc    `json:"docs,omitempty"`
+	Words []string `json:"words,omitempty"`
+	// n-gram order of Words, words without order are unigrams
+	WordOrders []int `json:"word_orders,omitempty"`
+	// n-gram range Words are generated with, zero in snapshots written before it was recorded
+	NGramMin int `json:"ngram_min,omitempty"`
+	NGramMax int `json:"ngram_max,omitempty"`
+
+	// number of docs ever upserted and the vocabulary growth sampled by it
+	Upserts int           `json:"upserts,omitempty"`
+	Growth  []GrowthPoint `json:"growth,omitempty"`
+
+	Classifier *ClassifierModel `json:"classifier,omitempty"`
+	ANN        *ANNSnapshot     `json:"ann,omitempty"`
+	Related    *RelatedSnapshot `json:"related,omitempty"`
+}
+
+// Store persists snapshots, Save should replace the stored snapshot atomically
+// so that readers loading concurrently see either the old or the new one
+type Store interface {
+	Load() (*Snapshot, error)
+	Save(s *Snapshot) error
+}
+
+// FileStore keeps docs and words in the data file and counters in the descriptor file.
+// Each file is replaced atomically, the data file first, a crash in between
+// leaves counters of the previous save which CheckSnapshot reports.
+type FileStore struct {
+	DataFile       string
+	DescriptorFile string
+	FileMode       os.FileMode
+}
+
+func NewFileStore(dataFile, descriptorFile string, mode os.FileMode) *FileStore {
+	return &FileStore{
+		DataFile:       dataFile,
+		DescriptorFile: descriptorFile,
+		FileMode:       mode,
+	}
+}
+
+func (f *FileStore) Load() (*Snapshot, error) {
+	fdData, err := ioutil.ReadFile(f.DescriptorFile)
+	if err != nil {
+		return nil, err
+	}
+	fd := Snapshot{}
+	err = json.Unmarshal(fdData, &fd)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(f.DataFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{
+		Docs:  make([]Doc, 0, fd.DocCount),
+		Words: make([]string, 0, fd.WordCount),
+	}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, err
+	}
+	s.DocCount = fd.DocCount
+	s.WordCount = fd.WordCount
+	s.Upserts = fd.Upserts
+	s.NGramMin = fd.NGramMin
+	s.NGramMax = fd.NGramMax
+	return s, nil
+}
+
+func (f *FileStore) Save(s *Snapshot) error {
+	data, err := json.Marshal(&Snapshot{
+		Docs:       s.Docs,
+		Words:      s.Words,
+		WordOrders: s.WordOrders,
+		Growth:     s.Growth,
+		Classifier: s.Classifier,
+		ANN:        s.ANN,
+		Related:    s.Related,
+	})
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(f.DataFile, data, f.FileMode)
+	if err != nil {
+		return err
+	}
+
+	fdData, err := json.Marshal(&Snapshot{
+		DocCount:  s.DocCount,
+		WordCount: s.WordCount,
+		Upserts:   s.Upserts,
+		NGramMin:  s.NGramMin,
+		NGramMax:  s.NGramMax,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.DescriptorFile, fdData, f.FileMode)
+}
+
+// writeFileAtomic writes a temp file in the same directory and renames it
+// over filename once synced, so that filename is never left half written
+func writeFileAtomic(filename string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(filename)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the rename itself is durable once the directory is synced
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Snapshot copies the persistent data
+func (t *TFIDF) Snapshot() *Snapshot {
+	defer t.Unlock()
+	t.Lock()
+	return t.snapshot()
+}
+
+func (t *TFIDF) snapshot() *Snapshot {
+	s := &Snapshot{
+		DocCount:   t.pd.DocCount,
+		WordCount:  t.pd.WordCount,
+		Docs:       make([]Doc, len(t.pd.Docs)),
+		Words:      make([]string, len(t.pd.Words)),
+		WordOrders: make([]int, len(t.pd.WordOrders)),
+		NGramMin:   t.ngramMin,
+		NGramMax:   t.ngramMax,
+		Upserts:    t.pd.Upserts,
+		Growth:     make([]GrowthPoint, len(t.pd.Growth)),
+		Classifier: t.classifier.get(),
+		ANN:        t.ann.snapshot(),
+		Related:    t.related.snapshot(),
+	}
+	copy(s.Growth, t.pd.Growth)
+	copy(s.Docs, t.pd.Docs)
+	copy(s.Words, t.pd.Words)
+	copy(s.WordOrders, t.pd.WordOrders)
+	return s
+}
+
+// LoadFromStore replaces all data by the snapshot in the store
+func (t *TFIDF) LoadFromStore(store Store) error {
+	s, err := store.Load()
+	if err != nil {
+		return err
+	}
+	err = t.checkNGramRange(s)
+	if err != nil {
+		return err
+	}
+	if issues := t.CheckSnapshot(s); len(issues) > 0 {
+		logIssues(issues)
+	}
+	defer t.Unlock()
+	t.Lock()
+	t.loadSnapshot(s)
+	return nil
+}
+
+// checkNGramRange rejects snapshots generated with another n-gram range,
+// their vocabulary lacks n-grams of t and keeps n-grams t never generates
+func (t *TFIDF) checkNGramRange(s *Snapshot) error {
+	if s.NGramMin == 0 && s.NGramMax == 0 {
+		return nil
+	}
+	if s.NGramMin != t.ngramMin || s.NGramMax != t.ngramMax {
+		return fmt.Errorf("snapshot is generated with n-gram range (%d, %d), loading it with (%d, %d)",
+			s.NGramMin, s.NGramMax, t.ngramMin, t.ngramMax)
+	}
+	return nil
+}
+
+func (t *TFIDF) loadSnapshot(s *Snapshot) {
+	t.pd.DocCount = s.DocCount
+	t.pd.WordCount = s.WordCount
+	t.pd.Docs = make([]Doc, len(s.Docs))
+	t.pd.Words = make([]string, len(s.Words))
+	t.pd.WordOrders = make([]int, len(s.Words))
+	t.pd.Upserts = s.Upserts
+	t.pd.Growth = make([]GrowthPoint, len(s.Growth))
+	copy(t.pd.Growth, s.Growth)
+	t.pd.updated = false
+	t.classifier.set(s.Classifier)
+
+	for i := range s.Docs {
+		doc := Doc{
+			ID:        s.Docs[i].ID,
+			Words:     make([]string, len(s.Docs[i].Words)),
+			Fields:    copyFields(s.Docs[i].Fields),
+			Synonyms:  copyFields(s.Docs[i].Synonyms),
+			ExpiresAt: s.Docs[i].ExpiresAt,
+			Labels:    s.Docs[i].Labels,
+		}
+		copy(doc.Words, s.Docs[i].Words)
+		t.pd.Docs[i] = doc
+	}
+	copy(t.pd.Words, s.Words)
+	for i := range t.pd.WordOrders {
+		t.pd.WordOrders[i] = 1
+	}
+	copy(t.pd.WordOrders, s.WordOrders)
+
+	t.wm = newWordMap()
+	t.dm = newDocMap()
+	t.sigs = newSignatureMap(t.sigs.n)
+	t.bk = &bkTree{}
+	t.initDerivedData()
+	ids := make([]string, len(t.pd.Docs))
+	for i := range t.pd.Docs {
+		ids[i] = t.pd.Docs[i].ID
+	}
+	t.related.load(ids, s.Related)
+	t.loadANN(s.ANN)
+}
+
+// SaveTo writes a snapshot to the store if anything changed since last save
+func (t *TFIDF) SaveTo(store Store) error {
+	t.Lock()
+	if !t.pd.updated {
+		t.Unlock()
+		return nil
+	}
+	s := t.snapshot()
+	t.pd.updated = false
+	t.Unlock()
+
+	err := store.Save(s)
+	if err != nil {
+		t.Lock()
+		t.pd.updated = true
+		t.Unlock()
+	}
+	return err
+}
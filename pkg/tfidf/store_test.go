@@ -0,0 +1,44 @@
+package tfidf
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStoreSave(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(filepath.Join(dir, "tfidf.json"), filepath.Join(dir, "fd.json"), 0640)
+	writer := NewTFIDF()
+	for _, docs := range [][]Doc{testDocs()[:1], testDocs()} {
+		writer.UpsertDocs(context.Background(), docs)
+		err := writer.SaveTo(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected temp files renamed into place, got %d files", len(files))
+	}
+	for _, f := range files {
+		if f.Mode().Perm() != 0640 {
+			t.Errorf("expected mode 0640 of %s, got %v", f.Name(), f.Mode())
+		}
+	}
+
+	reader := NewTFIDF()
+	err = reader.LoadFromStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(writer.Snapshot(), reader.Snapshot()) {
+		t.Fatalf("snapshot mismatch\nwant %+v\ngot  %+v", writer.Snapshot(), reader.Snapshot())
+	}
+}
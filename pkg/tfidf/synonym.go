@@ -0,0 +1,208 @@
+package tfidf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SynonymsAtIndex expands stored and ad-hoc docs, reloads only affect docs upserted later
+	SynonymsAtIndex = "index"
+	// SynonymsAtQuery expands search docs and query terms
+	SynonymsAtQuery = "query"
+)
+
+// Synonyms maps words to their synonyms. Lines of the dictionary are either
+// equivalences `car, automobile, auto` or one-way mappings `ny, nyc => new york`,
+// # starts a comment. Synonyms may be reloaded while in use.
+type Synonyms struct {
+	sync.RWMutex
+	filename string
+	m        map[string][]string
+	rules    int
+}
+
+type SynonymStats struct {
+	Rules int `json:"rules"`
+	Words int `json:"words"`
+}
+
+func ParseSynonyms(r io.Reader) (*Synonyms, error) {
+	s := &Synonyms{}
+	return s, s.parse(r)
+}
+
+// LoadSynonyms reads the dictionary file, which is read again by Reload
+func LoadSynonyms(filename string) (*Synonyms, error) {
+	s := &Synonyms{filename: filename}
+	return s, s.Reload()
+}
+
+func (s *Synonyms) Reload() error {
+	if s.filename == "" {
+		return fmt.Errorf("synonyms are not loaded from a file")
+	}
+	f, err := os.Open(s.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.parse(f)
+}
+
+func splitSynonyms(s string) []string {
+	res := make([]string, 0)
+	for _, w := range strings.Split(s, ",") {
+		if w = strings.Join(strings.Fields(w), ngramSeparator); w != "" {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+func (s *Synonyms) parse(r io.Reader) error {
+	m := make(map[string][]string)
+	seen := make(map[string]set)
+	add := func(from string, to []string) {
+		if seen[from] == nil {
+			seen[from] = make(set)
+		}
+		for _, w := range to {
+			if w == from || seen[from].exist(w) {
+				continue
+			}
+			seen[from].set(w)
+			m[from] = append(m[from], w)
+		}
+	}
+	rules := 0
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		rules++
+		if i := strings.Index(text, "=>"); i >= 0 {
+			from, to := splitSynonyms(text[:i]), splitSynonyms(text[i+2:])
+			if len(from) == 0 || len(to) == 0 {
+				return fmt.Errorf("invalid synonym mapping at line %d", line)
+			}
+			for _, w := range from {
+				add(w, to)
+			}
+			continue
+		}
+		words := splitSynonyms(text)
+		if len(words) < 2 {
+			return fmt.Errorf("synonym line %d has less than 2 words", line)
+		}
+		for _, w := range words {
+			add(w, words)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	defer s.Unlock()
+	s.Lock()
+	s.m = m
+	s.rules = rules
+	return nil
+}
+
+// Expand returns synonyms of the word, the word itself excluded
+func (s *Synonyms) Expand(word string) []string {
+	if s == nil {
+		return nil
+	}
+	defer s.RUnlock()
+	s.RLock()
+	return s.m[word]
+}
+
+func (s *Synonyms) Stats() SynonymStats {
+	if s == nil {
+		return SynonymStats{}
+	}
+	defer s.RUnlock()
+	s.RLock()
+	return SynonymStats{Rules: s.rules, Words: len(s.m)}
+}
+
+// WithSynonyms expands words at index or query time
+func WithSynonyms(s *Synonyms, mode string) Option {
+	return func(t *TFIDF) {
+		t.synonyms = s
+		t.synonymMode = mode
+	}
+}
+
+// expandWords returns synonyms of the words missing from them, expanded
+// records the word every synonym comes from
+func (s *Synonyms) expandWords(words []string, expanded map[string]string) []string {
+	seen := make(set, len(words))
+	for _, w := range words {
+		seen.set(w)
+	}
+	var res []string
+	for _, w := range words {
+		for _, syn := range s.Expand(w) {
+			if seen.exist(syn) {
+				continue
+			}
+			seen.set(syn)
+			res = append(res, syn)
+			if expanded != nil {
+				expanded[syn] = w
+			}
+		}
+	}
+	return res
+}
+
+// expandDoc replaces synonyms of the doc, they stay out of Words and Fields
+// so that n-grams and phrases only span words next to each other
+func (s *Synonyms) expandDoc(doc Doc, expanded map[string]string) Doc {
+	if s == nil {
+		return doc
+	}
+	doc.Synonyms = nil
+	for _, field := range doc.fieldNames() {
+		syns := s.expandWords(doc.fieldWords(field), expanded)
+		if len(syns) == 0 {
+			continue
+		}
+		if doc.Synonyms == nil {
+			doc.Synonyms = make(map[string][]string)
+		}
+		doc.Synonyms[field] = syns
+	}
+	return doc
+}
+
+// ReloadSynonyms reads the synonym dictionary file again
+func (s *Server) ReloadSynonyms(ctx *gin.Context) {
+	synonyms := s.engine().synonyms
+	if synonyms == nil {
+		abortWithError(ctx, http.StatusConflict, ErrCodeNotReady, fmt.Errorf("synonyms are not configured"))
+		return
+	}
+	err := synonyms.Reload()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, synonyms.Stats())
+}
@@ -0,0 +1,121 @@
+package tfidf
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func synonymTestTFIDF(t *testing.T, mode string) *TFIDF {
+	t.Helper()
+	synonyms, err := ParseSynonyms(strings.NewReader("car, automobile, car\nnyc => new york # one way\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tfidf := NewTFIDF(WithNGramRange(1, 2), WithSynonyms(synonyms, mode))
+	_, err = tfidf.UpsertDocs(context.Background(), []Doc{
+		{ID: "1", Words: []string{"red", "car"}},
+		{ID: "2", Words: []string{"nyc", "taxi"}},
+		{ID: "3", Words: []string{"new", "york", "pizza"}},
+		{ID: "4", Words: []string{"green", "tea"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tfidf
+}
+
+func queryIDs(t *testing.T, tfidf *TFIDF, q string) []string {
+	t.Helper()
+	res, err := tfidf.Query(context.Background(), q, 0, 10, false)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	ids := make([]string, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		ids = append(ids, hit.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestParseSynonyms(t *testing.T) {
+	synonyms, err := ParseSynonyms(strings.NewReader("car, automobile, car, auto\nny, nyc => new  york\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syns := synonyms.Expand("car"); !reflect.DeepEqual(syns, []string{"automobile", "auto"}) {
+		t.Fatalf("expected duplicates dropped, got %v", syns)
+	}
+	if syns := synonyms.Expand("nyc"); !reflect.DeepEqual(syns, []string{"new york"}) {
+		t.Fatalf("expected one way mapping to new york, got %v", syns)
+	}
+	if syns := synonyms.Expand("new york"); len(syns) != 0 {
+		t.Fatalf("expected no mapping back, got %v", syns)
+	}
+	if _, err := ParseSynonyms(strings.NewReader("car\n")); err == nil {
+		t.Fatal("expected a line of one word to be invalid")
+	}
+}
+
+func TestSynonymsAtIndex(t *testing.T) {
+	tfidf := synonymTestTFIDF(t, SynonymsAtIndex)
+
+	doc, _ := tfidf.GetDoc("1")
+	if !reflect.DeepEqual(doc.Words, []string{"red", "car"}) || !reflect.DeepEqual(doc.Synonyms[defaultField], []string{"automobile"}) {
+		t.Fatalf("expected synonyms kept apart from words, got %+v", doc)
+	}
+	// synonyms take no part in n-grams
+	for _, w := range []string{"car automobile", "taxi new", "taxi new york"} {
+		if _, ok := tfidf.LookupWord(w); ok {
+			t.Fatalf("unexpected n-gram %q across synonyms", w)
+		}
+	}
+	info, ok := tfidf.LookupWord("new york")
+	if !ok || info.DF != 2 || info.Order != 2 {
+		t.Fatalf("expected new york as a bigram of docs 2 and 3, got %+v", info)
+	}
+
+	if ids := queryIDs(t, tfidf, "automobile"); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("expected doc 1, got %v", ids)
+	}
+	if ids := queryIDs(t, tfidf, `"new york"`); !reflect.DeepEqual(ids, []string{"2", "3"}) {
+		t.Fatalf("expected docs 2 and 3, got %v", ids)
+	}
+	// synonyms take no part in phrases either
+	if ids := queryIDs(t, tfidf, `"red automobile"`); len(ids) != 0 {
+		t.Fatalf("expected no phrase across synonyms, got %v", ids)
+	}
+
+	ids := searchIDs(t, tfidf, SearchRequest{Doc: Doc{Words: []string{"auto", "automobile"}}, Limit: 10})
+	if !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("expected doc 1 found by its synonym, got %v", ids)
+	}
+}
+
+func TestSynonymsAtQuery(t *testing.T) {
+	tfidf := synonymTestTFIDF(t, SynonymsAtQuery)
+
+	doc, _ := tfidf.GetDoc("1")
+	if doc.Synonyms != nil {
+		t.Fatalf("expected stored docs unexpanded, got %+v", doc)
+	}
+	if ids := queryIDs(t, tfidf, "automobile"); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("expected doc 1, got %v", ids)
+	}
+	if ids := queryIDs(t, tfidf, "nyc"); !reflect.DeepEqual(ids, []string{"2", "3"}) {
+		t.Fatalf("expected docs 2 and 3, got %v", ids)
+	}
+
+	ids := searchIDs(t, tfidf, SearchRequest{Doc: Doc{Words: []string{"automobile"}}, Limit: 10})
+	if !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("expected doc 1 found by the synonym of the search doc, got %v", ids)
+	}
+	ids = searchIDs(t, tfidf, SearchRequest{Doc: Doc{Words: []string{"nyc"}}, Limit: 10})
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"2", "3"}) {
+		t.Fatalf("expected docs 2 and 3, got %v", ids)
+	}
+}
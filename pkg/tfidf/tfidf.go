@@ -1,24 +1,51 @@
 package tfidf
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"context"
 	"math"
+	"os"
 	"sync"
+	"time"
 )
 
 type TFIDF struct {
 	sync.Mutex
 	pd persistentData
 
+	ngramMin    int
+	ngramMax    int
+	fieldBoosts map[string]float64
+	perFieldIDF bool
+	fileMode    os.FileMode
+	analyzer    Analyzer
+	ttl         time.Duration
+	synonyms    *Synonyms
+	synonymMode string
+
 	// derived data, generated after persistent data loaded
-	wm *wordMap
-	dm *docMap
+	wm   *wordMap
+	dm   *docMap
+	sigs *signatureMap
+	bk   *bkTree
+
+	lsa        lsaHolder
+	classifier classifierHolder
+	feed       *changeFeed
+	related    *relatedIndex
+	ann        *annIndex
+
+	// expiry statistics since start
+	expired   int
+	lastSweep time.Time
 }
 
 type WordTFIDF struct {
 	Index int     `json:"index"`
+	Order int     `json:"order"`
+	Field string  `json:"field,omitempty"`
 	Value float64 `json:"value"`
+	// only filled when explanation is requested
+	Explanation *TermExplanation `json:"explanation,omitempty"`
 }
 
 type wordMap struct {
@@ -50,39 +77,48 @@ func newWordMap() *wordMap {
 	}
 }
 
+// docMap maps doc id to the position of the doc in persistentData.Docs,
+// pointers would be dangling once Docs grows.
 type docMap struct {
 	sync.Mutex
-	m map[string]*Doc
+	m map[string]int
 }
 
-func (dm *docMap) setDoc(d *Doc) {
+func (dm *docMap) setDoc(id string, i int) {
 	if dm == nil {
 		return
 	}
 	defer dm.Unlock()
 	dm.Lock()
-	dm.m[d.ID] = d
+	dm.m[id] = i
 }
 
-func (dm *docMap) getDoc(id string) *Doc {
+func (dm *docMap) getDoc(id string) int {
 	if dm == nil {
-		return nil
+		return -1
 	}
 	defer dm.Unlock()
 	dm.Lock()
-	return dm.m[id]
+	i, ok := dm.m[id]
+	if !ok {
+		return -1
+	}
+	return i
 }
 
 func newDocMap() *docMap {
 	return &docMap{
-		m: make(map[string]*Doc),
+		m: make(map[string]int),
 	}
 }
 
 type word struct {
 	value  string
 	index  int
+	order  int
 	docSet *docSet
+	// documents containing the word, grouped by field
+	fieldDocs *fieldDocSets
 }
 
 func (w *word) getIndex() int {
@@ -92,6 +128,13 @@ func (w *word) getIndex() int {
 	return w.index
 }
 
+func (w *word) getOrder() int {
+	if w == nil {
+		return 0
+	}
+	return w.order
+}
+
 func (w *word) addDoc(docID string) {
 	if w == nil {
 		return
@@ -106,33 +149,41 @@ func (w *word) delDoc(docID string) {
 	w.docSet.del(docID)
 }
 
+func (w *word) delFieldDoc(field, docID string) {
+	if w == nil {
+		return
+	}
+	w.fieldDocs.del(field, docID)
+}
+
 func (w *word) docCount() int {
 	if w == nil {
 		return 0
 	}
-	return len(w.docSet.m)
+	return w.docSet.size()
+}
+
+func (w *word) fieldDocCount(field string) int {
+	if w == nil {
+		return 0
+	}
+	return w.fieldDocs.get(field).size()
 }
 
 type persistentData struct {
 	sync.Mutex
 	updated bool
-
-	// store in data file descriptor
-	DocCount  int `json:"doc_count,omitempty"`
-	WordCount int `json:"word_count,omitempty"`
-
-	// store in data file
-	Docs  []Doc    `json:"docs,omitempty"`
-	Words []string `json:"words,omitempty"`
+	Snapshot
 }
 
-func (p *persistentData) appendWord(s string) int {
+func (p *persistentData) appendWord(s string, order int) int {
 	if p == nil {
 		return -1
 	}
 	defer p.Unlock()
 	p.Lock()
 	p.Words = append(p.Words, s)
+	p.WordOrders = append(p.WordOrders, order)
 	p.updated = true
 	p.WordCount = len(p.Words)
 	return len(p.Words) - 1
@@ -215,20 +266,37 @@ func (s *docSet) del(str string) {
 	s.m.del(str)
 }
 
-type Doc struct {
-	ID    string   `json:"id"`
-	Words []string `json:"words"`
+func (s *docSet) size() int {
+	if s == nil {
+		return 0
+	}
+	defer s.Unlock()
+	s.Lock()
+	return len(s.m)
 }
 
-func (d *Doc) wordsDiff(newWords []string) (incr, decr []string) {
-	if d == nil {
-		return nil, nil
-	}
+type Doc struct {
+	ID    string   `json:"id"`
+	Words []string `json:"words,omitempty"`
+	// words of named fields, e.g. title, body and tags
+	Fields map[string][]string `json:"fields,omitempty"`
+	// synonyms of words by field, "" for Words, indexed as terms of their own
+	// outside n-grams and phrases, index-time expansion replaces them
+	Synonyms map[string][]string `json:"synonyms,omitempty"`
+	// raw text analyzed into Words before indexing, never stored
+	Text string `json:"text,omitempty"`
+	// the doc is removed by the sweeper after this time
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// classes of the doc used to train the classifier
+	Labels []string `json:"labels,omitempty"`
+}
+
+func wordsDiff(oldWords, newWords []string) (incr, decr []string) {
 	incrSet := make(set)
 	decrSet := make(set)
-	for i := range d.Words {
-		incrSet.set(d.Words[i])
-		decrSet.set(d.Words[i])
+	for i := range oldWords {
+		incrSet.set(oldWords[i])
+		decrSet.set(oldWords[i])
 	}
 	for i := range newWords {
 		if !incrSet.exist(newWords[i]) {
@@ -241,166 +309,161 @@ func (d *Doc) wordsDiff(newWords []string) (incr, decr []string) {
 	return
 }
 
-func NewTFIDF() *TFIDF {
-	return &TFIDF{
-		wm: newWordMap(),
-		dm: newDocMap(),
-	}
-}
+// defaultFileMode is the permission of persisted files
+const defaultFileMode os.FileMode = 0777
 
-func (t *TFIDF) LoadFrom(pdFilename, fdFilename string) error {
-	defer t.Unlock()
-	t.Lock()
+type Option func(*TFIDF)
 
-	fdData, err := ioutil.ReadFile(fdFilename)
-	if err != nil {
-		return err
-	}
-	fd := persistentData{}
-	err = json.Unmarshal(fdData, &fd)
-	if err != nil {
-		return err
+// WithFileMode sets the permission of files written by Save
+func WithFileMode(mode os.FileMode) Option {
+	return func(t *TFIDF) {
+		t.fileMode = mode
 	}
-	t.pd.DocCount = fd.DocCount
-	t.pd.WordCount = fd.WordCount
+}
 
-	data, err := ioutil.ReadFile(pdFilename)
-	if err != nil {
-		return err
+func NewTFIDF(opts ...Option) *TFIDF {
+	t := &TFIDF{
+		ngramMin: 1,
+		ngramMax: 1,
+		fileMode: defaultFileMode,
+		wm:       newWordMap(),
+		dm:       newDocMap(),
+		sigs:     newSignatureMap(bands(DefaultDuplicateThreshold)),
+		bk:       &bkTree{},
+		feed:     newChangeFeed(defaultChangeFeedSize),
+		related:  newRelatedIndex(),
+		ann:      newANNIndex(),
 	}
-	pd := persistentData{
-		Docs:  make([]Doc, t.pd.DocCount),
-		Words: make([]string, t.pd.WordCount),
+	for _, opt := range opts {
+		opt(t)
 	}
-	err = json.Unmarshal(data, &pd)
-	if err != nil {
-		return err
+	if t.analyzer == nil {
+		t.analyzer, _ = NewSegmenter(nil)
 	}
+	return t
+}
 
-	t.pd.Docs = make([]Doc, len(pd.Docs))
-	t.pd.Words = make([]string, len(pd.Words))
+// analyze appends words of the text to Words, and synonyms at index time
+func (t *TFIDF) analyze(doc Doc) Doc {
+	return t.analyzeExpanded(doc, nil)
+}
 
-	for i := range pd.Docs {
-		doc := Doc{
-			ID:    pd.Docs[i].ID,
-			Words: make([]string, len(pd.Docs[i].Words)),
-		}
-		copy(doc.Words, pd.Docs[i].Words)
-		t.pd.Docs[i] = doc
+// analyzeExpanded records the word every index-time synonym comes from
+func (t *TFIDF) analyzeExpanded(doc Doc, expanded map[string]string) Doc {
+	if doc.Text != "" {
+		words := t.analyzer.Analyze(doc.Text)
+		doc.Words = append(append(make([]string, 0, len(doc.Words)+len(words)), doc.Words...), words...)
+		doc.Text = ""
+	}
+	if t.synonymMode == SynonymsAtIndex {
+		doc = t.synonyms.expandDoc(doc, expanded)
+	}
+	return doc
+}
+
+func (t *TFIDF) analyzeDocs(docs []Doc) []Doc {
+	res := make([]Doc, len(docs))
+	for i := range docs {
+		res[i] = t.analyze(docs[i])
 	}
-	copy(t.pd.Words, pd.Words)
+	return res
+}
 
-	t.initDerivedData()
-	return nil
+// LoadFrom loads the data file and its descriptor written by Save
+func (t *TFIDF) LoadFrom(pdFilename, fdFilename string) error {
+	return t.LoadFromStore(NewFileStore(pdFilename, fdFilename, t.fileMode))
 }
 
 func (t *TFIDF) initDerivedData() {
 	for i := range t.pd.Words {
 		t.wm.setWord(word{
-			docSet: newSet(),
-			index:  i,
-			value:  t.pd.Words[i],
+			docSet:    newSet(),
+			fieldDocs: newFieldDocSets(),
+			index:     i,
+			order:     t.pd.WordOrders[i],
+			value:     t.pd.Words[i],
 		})
+		if t.pd.WordOrders[i] == 1 {
+			t.bk.add(t.pd.Words[i])
+		}
 	}
 	for i := range t.pd.Docs {
-		t.dm.setDoc(&t.pd.Docs[i])
-		for j := range t.pd.Docs[i].Words {
-			w := t.wm.getWord(t.pd.Docs[i].Words[j])
+		t.dm.setDoc(t.pd.Docs[i].ID, i)
+		t.sigs.set(t.pd.Docs[i].ID, t.simHash(t.pd.Docs[i]))
+		terms := t.terms(t.pd.Docs[i])
+		for j := range terms {
+			w := t.wm.getWord(terms[j].value)
 			if w == nil {
-				t.appendWord(t.pd.Docs[i].Words[j], t.pd.Docs[i].ID)
+				t.appendWord(terms[j], t.pd.Docs[i].ID)
 				continue
 			}
 			w.addDoc(t.pd.Docs[i].ID)
+			w.fieldDocs.append(terms[j].field, t.pd.Docs[i].ID)
 			t.wm.setWord(*w)
 		}
 	}
 }
 
-func (t *TFIDF) appendWord(s, docID string) {
+func (t *TFIDF) appendWord(tm term, docID string) {
 	// check if the word already exists
-	w := t.wm.getWord(s)
+	w := t.wm.getWord(tm.value)
 	if w != nil {
 		return
 	}
 	w = new(word)
 	w.docSet = newSet()
-	w.docSet.append(s)
-	w.value = s
-	w.index = t.pd.appendWord(s)
+	w.docSet.append(docID)
+	w.fieldDocs = newFieldDocSets()
+	w.fieldDocs.append(tm.field, docID)
+	w.value = tm.value
+	w.order = tm.order
+	w.index = t.pd.appendWord(tm.value, tm.order)
 	t.wm.setWord(*w)
+	if tm.order == 1 {
+		t.bk.add(tm.value)
+	}
 }
 
+// Save writes the data file and its descriptor if anything changed since last save
 func (t *TFIDF) Save(pdFilename, fdFilename string) error {
-	t.Lock()
-	if !t.pd.updated {
-		t.Unlock()
-		return nil
-	}
-
-	pd := persistentData{
-		Docs:  make([]Doc, len(t.pd.Docs)),
-		Words: make([]string, len(t.pd.Words)),
-	}
-	copy(pd.Docs, t.pd.Docs)
-	copy(pd.Words, t.pd.Words)
-	t.pd.updated = false
-
-	fd := persistentData{
-		DocCount:  t.pd.DocCount,
-		WordCount: t.pd.WordCount,
-	}
-
-	t.Unlock()
-
-	data, err := json.Marshal(pd)
-	if err != nil {
-		return err
-	}
-
-	err = ioutil.WriteFile(pdFilename, data, 0777)
-	if err != nil {
-		return err
-	}
-
-	fdData, err := json.Marshal(fd)
-	if err != nil {
-		return err
-	}
-
-	err = ioutil.WriteFile(fdFilename, fdData, 0777)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return t.SaveTo(NewFileStore(pdFilename, fdFilename, t.fileMode))
 }
 
 func (t *TFIDF) DocCount() int {
+	defer t.pd.Unlock()
+	t.pd.Lock()
 	return len(t.pd.Docs)
 }
 
 func (t *TFIDF) WordCount() int {
+	defer t.pd.Unlock()
+	t.pd.Lock()
 	return len(t.pd.Words)
 }
 
+// TF accepts both unigrams and n-grams joined by a single space as word,
+// occurrences in every field are weighted by the boost of the field.
 func (t *TFIDF) TF(doc Doc, word string) float64 {
-	count := 0
-	for i := range doc.Words {
-		if doc.Words[i] == word {
-			count++
+	terms := t.terms(doc)
+	count := 0.0
+	for i := range terms {
+		if terms[i].value == word {
+			count += t.fieldBoost(terms[i].field)
 		}
 	}
-	return float64(count) / float64(len(doc.Words))
+	return count / float64(len(terms))
 }
 
 func (t *TFIDF) TFVector(doc Doc) []float64 {
-	countMap := make(map[string]int)
-	for i := range doc.Words {
-		countMap[doc.Words[i]]++
+	terms := t.terms(doc)
+	countMap := make(map[term]int)
+	for i := range terms {
+		countMap[terms[i]]++
 	}
-	res := make([]float64, 0, len(doc.Words))
-	for i := range doc.Words {
-		res = append(res, float64(countMap[doc.Words[i]])/float64(len(doc.Words)))
+	res := make([]float64, 0, len(terms))
+	for i := range terms {
+		boost := t.fieldBoost(terms[i].field)
+		res = append(res, boost*float64(countMap[terms[i]])/float64(len(terms)))
 	}
 	return res
 }
@@ -409,22 +472,55 @@ func (t *TFIDF) IDF(w string) float64 {
 	return math.Log(float64(t.DocCount()) / float64(t.wm.getWord(w).docCount()+1))
 }
 
+// FieldIDF only counts documents containing the word in the field
+func (t *TFIDF) FieldIDF(field, w string) float64 {
+	return math.Log(float64(t.DocCount()) / float64(t.wm.getWord(w).fieldDocCount(field)+1))
+}
+
+func (t *TFIDF) termIDF(tm term) float64 {
+	if t.perFieldIDF {
+		return t.FieldIDF(tm.field, tm.value)
+	}
+	return t.IDF(tm.value)
+}
+
 func (t *TFIDF) IDFVector(doc Doc) []float64 {
-	res := make([]float64, 0, len(doc.Words))
-	for i := range doc.Words {
-		res = append(res, t.IDF(doc.Words[i]))
+	terms := t.terms(doc)
+	res := make([]float64, 0, len(terms))
+	for i := range terms {
+		res = append(res, t.termIDF(terms[i]))
 	}
 	return res
 }
 
-func (t *TFIDF) GetDocVector(doc Doc) []*WordTFIDF {
-	t.UpsertDocs([]Doc{doc})
+func (t *TFIDF) GetDocVector(ctx context.Context, doc Doc) ([]*WordTFIDF, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	doc = t.analyze(doc)
+	if err := t.checkTerms("", doc); err != nil {
+		return nil, err
+	}
+	return t.getDocVector(doc), nil
+}
+
+// getDocVector upserts the analyzed doc
+func (t *TFIDF) getDocVector(doc Doc) []*WordTFIDF {
+	t.upsertDoc(doc)
+	return t.vector(doc)
+}
 
-	res := make([]*WordTFIDF, 0, len(doc.Words))
+// vector computes values of the analyzed doc with the current IDF
+func (t *TFIDF) vector(doc Doc) []*WordTFIDF {
+	terms := t.terms(doc)
+	res := make([]*WordTFIDF, 0, len(terms))
 	values := t.dotProduct(t.TFVector(doc), t.IDFVector(doc))
-	for i := range doc.Words {
+	for i := range terms {
+		w := t.wm.getWord(terms[i].value)
 		res = append(res, &WordTFIDF{
-			Index: t.wm.getWord(doc.Words[i]).getIndex(),
+			Index: w.getIndex(),
+			Order: w.getOrder(),
+			Field: terms[i].field,
 			Value: values[i],
 		})
 	}
@@ -446,29 +542,54 @@ func (t *TFIDF) dotProduct(a, b []float64) []float64 {
 	return dp(b, a)
 }
 
-// documents shares the same id would be saved by `Last Write Wins` strategy
-func (t *TFIDF) UpsertDocs(docs []Doc) {
+// documents shares the same id would be saved by `Last Write Wins` strategy,
+// cancellation stops between docs and reports how many were upserted
+func (t *TFIDF) UpsertDocs(ctx context.Context, docs []Doc) (int, error) {
+	docs, err := t.analyzeChecked(docs)
+	if err != nil {
+		return 0, err
+	}
 	for i := range docs {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
 		t.upsertDoc(docs[i])
 	}
+	return len(docs), nil
 }
 
 func (t *TFIDF) upsertDoc(doc Doc) {
 	defer t.Unlock()
 	t.Lock()
+	defer t.pd.countUpsert()
+	// hashed once words of the doc are indexed
+	defer func() { t.ann.set(doc.ID, t.docVector(doc)) }()
+	if doc.ExpiresAt == nil && t.ttl > 0 {
+		at := time.Now().Add(t.ttl).UTC()
+		doc.ExpiresAt = &at
+	}
 
-	preDoc := t.dm.getDoc(doc.ID)
+	t.sigs.set(doc.ID, t.simHash(doc))
+	t.related.touch(doc.ID)
+	preDoc := t.getDoc(doc.ID)
 	if preDoc == nil {
 		i := t.pd.appendDoc(doc)
-		t.dm.setDoc(&t.pd.Docs[i])
+		t.dm.setDoc(doc.ID, i)
 		t.reIndexWords(doc)
+		added, _ := changedWords(nil, termValues(t.terms(doc)))
+		t.feed.publish(Change{DocID: doc.ID, Op: OpInsert, Added: added})
 		return
 	}
 
-	incr, decr := preDoc.wordsDiff(doc.Words)
-	if len(incr) > 0 {
-		t.reIndexWords(doc)
+	preTerms := t.terms(*preDoc)
+	for i := range preTerms {
+		t.wm.getWord(preTerms[i].value).delFieldDoc(preTerms[i].field, doc.ID)
 	}
+	terms := termValues(t.terms(doc))
+	_, decr := wordsDiff(termValues(preTerms), terms)
+	t.reIndexWords(doc)
+	added, removed := changedWords(termValues(preTerms), terms)
+	t.feed.publish(Change{DocID: doc.ID, Op: OpUpdate, Added: added, Removed: removed})
 	for i := range decr {
 		w := t.wm.getWord(decr[i])
 		if w == nil {
@@ -479,21 +600,43 @@ func (t *TFIDF) upsertDoc(doc Doc) {
 
 	t.pd.Lock()
 	preDoc.Words = doc.Words
+	preDoc.Fields = doc.Fields
+	preDoc.Synonyms = doc.Synonyms
+	preDoc.ExpiresAt = doc.ExpiresAt
+	preDoc.Labels = doc.Labels
 	t.pd.updated = true
 	t.pd.Unlock()
 }
 
+// getDoc returns the stored doc, the pointer is only valid until next doc appended
+func (t *TFIDF) getDoc(id string) *Doc {
+	i := t.dm.getDoc(id)
+	if i < 0 {
+		return nil
+	}
+	return &t.pd.Docs[i]
+}
+
 func (t *TFIDF) reIndexWords(doc Doc) {
-	for i := range doc.Words {
-		w := t.wm.getWord(doc.Words[i])
+	terms := t.terms(doc)
+	for i := range terms {
+		w := t.wm.getWord(terms[i].value)
 		if w == nil {
-			t.wm.setWord(word{
-				value:  doc.Words[i],
-				index:  t.pd.appendWord(doc.Words[i]),
-				docSet: newSet().append(doc.ID),
-			})
+			w = &word{
+				value:     terms[i].value,
+				index:     t.pd.appendWord(terms[i].value, terms[i].order),
+				order:     terms[i].order,
+				docSet:    newSet().append(doc.ID),
+				fieldDocs: newFieldDocSets(),
+			}
+			w.fieldDocs.append(terms[i].field, doc.ID)
+			t.wm.setWord(*w)
+			if terms[i].order == 1 {
+				t.bk.add(terms[i].value)
+			}
 			continue
 		}
 		w.docSet.append(doc.ID)
+		w.fieldDocs.append(terms[i].field, doc.ID)
 	}
 }
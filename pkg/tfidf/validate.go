@@ -0,0 +1,133 @@
+package tfidf
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ErrCodeInvalidJSON      = "invalid_json"
+	ErrCodeInvalidParameter = "invalid_parameter"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeNotReady         = "not_ready"
+	ErrCodeInternal         = "internal"
+	ErrCodeTooLarge         = "too_large"
+	ErrCodeCanceled         = "canceled"
+	ErrCodeTimeout          = "timeout"
+)
+
+// ErrorBody is the response body of every failed request
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// path of the invalid parameter, e.g. [2].id or limit
+	Field string `json:"field,omitempty"`
+}
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func invalidf(field, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// validate checks the doc whose path in the request is prefix
+func (d Doc) validate(prefix string, requireID bool) error {
+	if requireID && strings.TrimSpace(d.ID) == "" {
+		return invalidf(prefix+"id", "id is required")
+	}
+	words := 0
+	for _, field := range d.fieldNames() {
+		path := prefix + "words"
+		if field != defaultField {
+			path = prefix + "fields." + field
+		}
+		for i, w := range d.fieldWords(field) {
+			if w == "" {
+				return invalidf(fmt.Sprintf("%s[%d]", path, i), "word is empty")
+			}
+			words++
+		}
+	}
+	for field, syns := range d.Synonyms {
+		for i, syn := range syns {
+			if strings.TrimSpace(syn) == "" {
+				return invalidf(fmt.Sprintf("%ssynonyms.%s[%d]", prefix, field, i), "synonym is empty")
+			}
+		}
+	}
+	if _, ok := d.Fields[defaultField]; ok {
+		return invalidf(prefix+"fields", "field name is empty")
+	}
+	for i, label := range d.Labels {
+		if strings.TrimSpace(label) == "" {
+			return invalidf(fmt.Sprintf("%slabels[%d]", prefix, i), "label is empty")
+		}
+	}
+	if words == 0 && strings.TrimSpace(d.Text) == "" {
+		return invalidf(prefix+"words", "doc has no words or text")
+	}
+	return nil
+}
+
+// checkTerms rejects analyzed docs without terms, e.g. text of punctuation
+// only, which would count in N and shift IDF without adding a word
+func (t *TFIDF) checkTerms(prefix string, doc Doc) error {
+	if len(t.terms(doc)) == 0 {
+		return invalidf(prefix+"words", "doc has no words after analysis, or fewer than the n-gram range needs")
+	}
+	return nil
+}
+
+// analyzeChecked analyzes all docs before any is upserted, so that a batch
+// with a doc without terms is rejected as a whole
+func (t *TFIDF) analyzeChecked(docs []Doc) ([]Doc, error) {
+	docs = t.analyzeDocs(docs)
+	for i := range docs {
+		err := t.checkTerms(fmt.Sprintf("[%d].", i), docs[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// ValidateDocs checks docs like /upsert_docs does
+func ValidateDocs(docs []Doc) error {
+	return validateDocs(docs, true)
+}
+
+// validateDocs rejects empty batches, invalid docs and ids occurring more than once
+func validateDocs(docs []Doc, requireID bool) error {
+	if len(docs) == 0 {
+		return invalidf("", "at least one doc is required")
+	}
+	seen := make(map[string]int, len(docs))
+	for i := range docs {
+		prefix := fmt.Sprintf("[%d].", i)
+		err := docs[i].validate(prefix, requireID)
+		if err != nil {
+			return err
+		}
+		if docs[i].ID == "" {
+			continue
+		}
+		if j, ok := seen[docs[i].ID]; ok {
+			return invalidf(prefix+"id", "duplicate id %q, first seen at [%d]", docs[i].ID, j)
+		}
+		seen[docs[i].ID] = i
+	}
+	return nil
+}
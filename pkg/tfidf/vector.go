@@ -0,0 +1,104 @@
+package tfidf
+
+import (
+	"math"
+	"sort"
+)
+
+// sparseVector maps word index to its value
+type sparseVector map[int]float64
+
+func (v sparseVector) norm() float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x * x
+	}
+	return math.Sqrt(sum)
+}
+
+// normalize scales the vector to unit length in place
+func (v sparseVector) normalize() sparseVector {
+	n := v.norm()
+	if n == 0 {
+		return v
+	}
+	for i := range v {
+		v[i] /= n
+	}
+	return v
+}
+
+func (v sparseVector) dot(o sparseVector) float64 {
+	if len(v) > len(o) {
+		v, o = o, v
+	}
+	sum := 0.0
+	for i, x := range v {
+		sum += x * o[i]
+	}
+	return sum
+}
+
+func (v sparseVector) add(o sparseVector) {
+	for i, x := range o {
+		v[i] += x
+	}
+}
+
+// top returns indexes of the n largest values
+func (v sparseVector) top(n int) []int {
+	indexes := make([]int, 0, len(v))
+	for i := range v {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		if v[indexes[i]] == v[indexes[j]] {
+			return indexes[i] < indexes[j]
+		}
+		return v[indexes[i]] > v[indexes[j]]
+	})
+	if n >= 0 && len(indexes) > n {
+		indexes = indexes[:n]
+	}
+	return indexes
+}
+
+// docVector computes TF-IDF of the doc by word index without upserting it,
+// words out of the vocabulary are ignored and values of the same word in
+// different fields are summed up.
+func (t *TFIDF) docVector(doc Doc) sparseVector {
+	terms := t.terms(doc)
+	countMap := make(map[term]int)
+	for i := range terms {
+		countMap[terms[i]]++
+	}
+	vec := make(sparseVector, len(countMap))
+	for tm, count := range countMap {
+		index := t.wm.getWord(tm.value).getIndex()
+		if index < 0 {
+			continue
+		}
+		tf := t.fieldBoost(tm.field) * float64(count) / float64(len(terms))
+		vec[index] += tf * t.termIDF(tm)
+	}
+	return vec
+}
+
+// storedDocs copies docs under lock, words and fields are shared with the
+// stored docs since upsert replaces them instead of modifying in place.
+func (t *TFIDF) storedDocs() []Doc {
+	defer t.Unlock()
+	t.Lock()
+	docs := make([]Doc, len(t.pd.Docs))
+	copy(docs, t.pd.Docs)
+	return docs
+}
+
+func (t *TFIDF) wordValue(index int) (string, bool) {
+	defer t.pd.Unlock()
+	t.pd.Lock()
+	if index < 0 || index >= len(t.pd.Words) {
+		return "", false
+	}
+	return t.pd.Words[index], true
+}